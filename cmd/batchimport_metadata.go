@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/arangodb/go-driver"
+)
+
+// batchImportMetadataKey is the key of the document in which write
+// batchimport records the key space it has written.
+const batchImportMetadataKey = "collectionmaker-metadata"
+
+// batchImportMetadata describes the documents written by write batchimport:
+// documents 0 to TotalNumber-1 with keys of KeySize hex digits.
+type batchImportMetadata struct {
+	Key         string `json:"_key"`
+	TotalNumber int64  `json:"totalNumber"`
+	KeySize     int    `json:"keySize"`
+}
+
+// readBatchImportMetadata returns the metadata document of the collection
+// or nil if there is none.
+func readBatchImportMetadata(ctx context.Context, coll driver.Collection) (*batchImportMetadata, error) {
+	var meta batchImportMetadata
+	if _, err := coll.ReadDocument(ctx, batchImportMetadataKey, &meta); err != nil {
+		if driver.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// writeBatchImportMetadata records that documents 0 to totalNumber-1 with
+// keys of keySize hex digits have been written. Documents written by
+// earlier runs with the same key size are still counted.
+func writeBatchImportMetadata(ctx context.Context, coll driver.Collection, totalNumber int64, keySize int) error {
+	meta, err := readBatchImportMetadata(ctx, coll)
+	if err != nil {
+		return err
+	}
+	if meta != nil && meta.KeySize == keySize && meta.TotalNumber > totalNumber {
+		totalNumber = meta.TotalNumber
+	}
+	if meta != nil && meta.KeySize != keySize {
+		fmt.Printf("Warning: collection `%s` was written with key size %d before, now %d.\n",
+			coll.Name(), meta.KeySize, keySize)
+	}
+	_, err = coll.CreateDocument(driver.WithOverwriteMode(ctx, driver.OverwriteModeReplace), &batchImportMetadata{
+		Key:         batchImportMetadataKey,
+		TotalNumber: totalNumber,
+		KeySize:     keySize,
+	})
+	return err
+}
+
+// batchImportKeySpace returns the number of documents and the key size
+// given by the flags `totalNumber` and `keySize` if they are not 0, by the
+// metadata document of write batchimport or else by the number of
+// documents in the collection. Flags which contradict the metadata are an
+// error.
+func batchImportKeySpace(ctx context.Context, coll driver.Collection, totalNumber int64, keySize int) (int64, int, error) {
+	meta, err := readBatchImportMetadata(ctx, coll)
+	if err != nil {
+		fmt.Printf("Could not read metadata document: %v\n", err)
+		return 0, 0, err
+	}
+	if meta != nil {
+		if totalNumber == 0 {
+			totalNumber = meta.TotalNumber
+		} else if totalNumber > meta.TotalNumber {
+			return 0, 0, fmt.Errorf("--total-number %d is larger than the %d documents written by write batchimport",
+				totalNumber, meta.TotalNumber)
+		}
+		if keySize == 0 {
+			keySize = meta.KeySize
+		} else if keySize != meta.KeySize {
+			return 0, 0, fmt.Errorf("--key-size %d does not match the key size %d used by write batchimport",
+				keySize, meta.KeySize)
+		}
+	} else {
+		if keySize == 0 {
+			keySize = 64
+		}
+		if totalNumber == 0 {
+			if totalNumber, err = coll.Count(ctx); err != nil {
+				fmt.Printf("Could not count documents: %v\n", err)
+				return 0, 0, err
+			}
+			fmt.Printf("No metadata document found in `%s`, using its %d documents as key space.\n",
+				coll.Name(), totalNumber)
+		}
+	}
+	if totalNumber < 1 {
+		return 0, 0, fmt.Errorf("collection `%s` has no documents", coll.Name())
+	}
+	if keySize < 1 || keySize > 64 {
+		return 0, 0, fmt.Errorf("invalid key size: %d", keySize)
+	}
+	return totalNumber, keySize, nil
+}
+
+// countBatchImportDocuments returns the number of documents in the
+// collection without the metadata document.
+func countBatchImportDocuments(ctx context.Context, coll driver.Collection) (int64, error) {
+	count, err := coll.Count(ctx)
+	if err != nil {
+		return 0, err
+	}
+	found, err := coll.DocumentExists(ctx, batchImportMetadataKey)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		count--
+	}
+	return count, nil
+}
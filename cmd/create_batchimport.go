@@ -1,11 +1,11 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"github.com/arangodb/go-driver"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"time"
 )
 
 var (
@@ -21,24 +21,50 @@ func init() {
 	var replicationFactor int
 	var numberOfShards int
 	var collectionName string
+	var withGeoIndex bool
+	var withSearchView bool
+	var viewName string
 
 	cmdCreateBatchImport.Flags().BoolVar(&drop, "drop", drop, "set -drop to true to drop data before start")
 	cmdCreateBatchImport.Flags().IntVar(&replicationFactor, "replicationFactor", 3, "replication factor for edge collection")
 	cmdCreateBatchImport.Flags().IntVar(&numberOfShards, "numberOfShards", 1, "number of shards of batch import collection")
 	cmdCreateBatchImport.Flags().StringVar(&collectionName, "collection", "batchimport", "name of batch import collection")
+	cmdCreateBatchImport.Flags().BoolVar(&withGeoIndex, "with-geo-index", false, "set -with-geo-index to create a geo index on the `geo` attribute")
+	cmdCreateBatchImport.Flags().BoolVar(&withSearchView, "with-search-view", false, "set -with-search-view to create an arangosearch view over the `words` attribute")
+	cmdCreateBatchImport.Flags().StringVar(&viewName, "view", "", "name of arangosearch view, default is the collection name with suffix `_view`")
+	indexesFlag(cmdCreateBatchImport)
+	databaseFlag(cmdCreateBatchImport)
 }
 
 func createBatchImport(cmd *cobra.Command, _ []string) error {
 	drop, _ := cmd.Flags().GetBool("drop")
-	db, err := _client.Database(context.Background(), "_system")
+	db, err := getDatabase(cmd)
 	if err != nil {
-		return errors.Wrapf(err, "can not get database: %s", "_system")
+		return err
 	}
 
 	if err := setupBatchImport(cmd, drop, db); err != nil {
 		return errors.Wrapf(err, "can not create batch import collection")
 	}
 
+	collectionName, _ := cmd.Flags().GetString("collection")
+	withGeoIndex, _ := cmd.Flags().GetBool("with-geo-index")
+	withSearchView, _ := cmd.Flags().GetBool("with-search-view")
+	viewName, _ := cmd.Flags().GetString("view")
+	if withGeoIndex {
+		if err := ensureGeoIndex(db, collectionName); err != nil {
+			return errors.Wrapf(err, "can not create geo index")
+		}
+	}
+	if withSearchView {
+		if err := ensureSearchView(db, collectionName, searchViewName(collectionName, viewName)); err != nil {
+			return errors.Wrapf(err, "can not create arangosearch view")
+		}
+	}
+	if err := ensureIndexes(cmd, db, collectionName); err != nil {
+		return errors.Wrapf(err, "can not create indexes")
+	}
+
 	return nil
 }
 
@@ -76,3 +102,98 @@ func setupBatchImport(cmd *cobra.Command, drop bool, db driver.Database) error {
 	}
 	return nil
 }
+
+// ensureGeoIndex creates a geo index on the GeoJSON attribute `geo`.
+func ensureGeoIndex(db driver.Database, collectionName string) error {
+	ec, err := db.Collection(nil, collectionName)
+	if err != nil {
+		fmt.Printf("Error: could not open batchimport collection: %v\n", err)
+		return err
+	}
+	start := time.Now()
+	_, created, err := ec.EnsureGeoIndex(nil, []string{"geo"}, &driver.EnsureGeoIndexOptions{GeoJSON: true})
+	if err != nil {
+		fmt.Printf("Error: could not create geo index: %v\n", err)
+		return err
+	}
+	if created {
+		fmt.Printf("Created geo index in %v.\n", time.Since(start))
+	}
+	return nil
+}
+
+// searchAnalyzer is the analyzer for the `words` attribute. It does not
+// stem, so that every word of wordList is found as written.
+const searchAnalyzer = "collectionmaker_words"
+
+// searchViewName returns the name of the arangosearch view over the
+// collection, `viewName` if it is given.
+func searchViewName(collectionName string, viewName string) string {
+	if viewName != "" {
+		return viewName
+	}
+	return collectionName + "_view"
+}
+
+// ensureSearchView creates the analyzer and an arangosearch view which
+// indexes the `words` attribute of the collection. An existing view gets
+// the link to the collection.
+func ensureSearchView(db driver.Database, collectionName string, viewName string) error {
+	noStemming := false
+	noAccents := false
+	_, _, err := db.EnsureAnalyzer(nil, driver.ArangoSearchAnalyzerDefinition{
+		Name: searchAnalyzer,
+		Type: driver.ArangoSearchAnalyzerTypeText,
+		Properties: driver.ArangoSearchAnalyzerProperties{
+			Locale:    "en",
+			Case:      driver.ArangoSearchCaseLower,
+			Accent:    &noAccents,
+			Stemming:  &noStemming,
+			Stopwords: []string{},
+		},
+		Features: []driver.ArangoSearchAnalyzerFeature{
+			driver.ArangoSearchAnalyzerFeatureFrequency,
+			driver.ArangoSearchAnalyzerFeatureNorm,
+			driver.ArangoSearchAnalyzerFeaturePosition,
+		},
+	})
+	if err != nil {
+		fmt.Printf("Error: could not create analyzer: %v\n", err)
+		return err
+	}
+
+	props := driver.ArangoSearchViewProperties{
+		Links: driver.ArangoSearchLinks{
+			collectionName: driver.ArangoSearchElementProperties{
+				// Stored ids let test search count the linked documents with EXISTS:
+				StoreValues: driver.ArangoSearchStoreValuesID,
+				Fields: driver.ArangoSearchFields{
+					"words": driver.ArangoSearchElementProperties{Analyzers: []string{searchAnalyzer}},
+				},
+			},
+		},
+	}
+	start := time.Now()
+	view, err := db.View(nil, viewName)
+	if err == nil {
+		sv, err := view.ArangoSearchView()
+		if err == nil {
+			err = sv.SetProperties(nil, props)
+		}
+		if err != nil {
+			fmt.Printf("Error: could not link view %s: %v\n", viewName, err)
+			return err
+		}
+		fmt.Printf("Found view %s already, linked it to %s in %v.\n", viewName, collectionName, time.Since(start))
+		return nil
+	} else if !driver.IsNotFound(err) {
+		fmt.Printf("Error: could not look for view %s: %v\n", viewName, err)
+		return err
+	}
+	if _, err := db.CreateArangoSearchView(nil, viewName, &props); err != nil {
+		fmt.Printf("Error: could not create view %s: %v\n", viewName, err)
+		return err
+	}
+	fmt.Printf("Created view %s in %v.\n", viewName, time.Since(start))
+	return nil
+}
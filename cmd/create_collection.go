@@ -35,6 +35,7 @@ func init() {
 		"Name of database which should be used")
 	cmdCreateCollection.Flags().StringVar(&collection, "collection", "test",
 		"Name of collection which should be used")
+	indexesFlag(cmdCreateCollection)
 
 	cmdCreateCollection.AddCommand(cmdCreateCollectionFile)
 	cmdCreateCollectionFile.Flags().StringVar(&file, "file", "",
@@ -44,6 +45,7 @@ func init() {
 		"Name of database which should be used")
 	cmdCreateCollectionFile.Flags().StringVar(&collection, "collection", "test",
 		"Name of collection which should be used")
+	indexesFlag(cmdCreateCollectionFile)
 }
 
 func createCollectionFromFile(cmd *cobra.Command, _ []string) error {
@@ -52,6 +54,11 @@ func createCollectionFromFile(cmd *cobra.Command, _ []string) error {
 	colName, _ := cmd.Flags().GetString("collection")
 	shards, _ := cmd.Flags().GetInt("shards")
 
+	indexes, err := getIndexSpecs(cmd)
+	if err != nil {
+		return err
+	}
+
 	options := driver.CreateCollectionOptions{
 		NumberOfShards: shards,
 	}
@@ -74,7 +81,11 @@ func createCollectionFromFile(cmd *cobra.Command, _ []string) error {
 		Scanner: scanner,
 	}, colHandle)
 
-	return creator.CreateDocuments(context.Background())
+	if err := creator.CreateDocuments(context.Background()); err != nil {
+		return err
+	}
+
+	return database.EnsureIndexes(context.Background(), _client.Connection(), colHandle.Database(), colName, indexes)
 }
 
 func createCollection(cmd *cobra.Command, _ []string) error {
@@ -92,6 +103,11 @@ func createCollection(cmd *cobra.Command, _ []string) error {
 		return errors.New("file with the count should be provided --countfile")
 	}
 
+	indexes, err := getIndexSpecs(cmd)
+	if err != nil {
+		return err
+	}
+
 	options := driver.CreateCollectionOptions{
 		NumberOfShards: shards,
 	}
@@ -106,5 +122,9 @@ func createCollection(cmd *cobra.Command, _ []string) error {
 		ExpectedCount: expectedCount,
 	}, colHandle)
 
-	return creator.CreateDocuments(context.Background())
+	if err := creator.CreateDocuments(context.Background()); err != nil {
+		return err
+	}
+
+	return database.EnsureIndexes(context.Background(), _client.Connection(), colHandle.Database(), colName, indexes)
 }
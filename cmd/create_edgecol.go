@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"github.com/arangodb/go-driver"
 	"github.com/pkg/errors"
@@ -20,17 +19,20 @@ func init() {
 	var drop = false
 	var replicationFactor int
 	var numberOfShards int
+	var collectionName string
 
 	cmdCreateEdgeCol.Flags().BoolVar(&drop, "drop", drop, "set -drop to true to drop data before start")
 	cmdCreateEdgeCol.Flags().IntVar(&replicationFactor, "replicationFactor", 3, "replication factor for edge collection")
 	cmdCreateEdgeCol.Flags().IntVar(&numberOfShards, "numberOfShards", 42, "number of shards of edge collection")
+	cmdCreateEdgeCol.Flags().StringVar(&collectionName, "collection", "edges", "name of edge collection")
+	databaseFlag(cmdCreateEdgeCol)
 }
 
 func createEdgeCol(cmd *cobra.Command, _ []string) error {
 	drop, _ := cmd.Flags().GetBool("drop")
-	db, err := _client.Database(context.Background(), "_system")
+	db, err := getDatabase(cmd)
 	if err != nil {
-		return errors.Wrapf(err, "can not get database: %s", "_system")
+		return err
 	}
 
 	if err := setupEdgeCol(cmd, drop, db); err != nil {
@@ -44,8 +46,9 @@ func createEdgeCol(cmd *cobra.Command, _ []string) error {
 func setupEdgeCol(cmd *cobra.Command, drop bool, db driver.Database) error {
 	replicationFactor, _ := cmd.Flags().GetInt("replicationFactor")
 	numberOfShards, _ := cmd.Flags().GetInt("numberOfShards")
+	collectionName, _ := cmd.Flags().GetString("collection")
 
-	ec, err := db.Collection(nil, "edges")
+	ec, err := db.Collection(nil, collectionName)
 	if err == nil {
 		if !drop {
 			fmt.Printf("Found edge collection already, setup is already done.\n")
@@ -62,7 +65,7 @@ func setupEdgeCol(cmd *cobra.Command, drop bool, db driver.Database) error {
 	}
 
 	// Now create the edge collection:
-	edges, err := db.CreateCollection(nil, "edges", &driver.CreateCollectionOptions{
+	edges, err := db.CreateCollection(nil, collectionName, &driver.CreateCollectionOptions{
 			Type: driver.CollectionTypeEdge,
 			NumberOfShards: numberOfShards,
 			ReplicationFactor: replicationFactor,
@@ -25,6 +25,7 @@ func init() {
 	cmdCreateFromDebugScript.Flags().StringVar(&countFilename, "countfile", "",
 		"File which contains number of documents in shards")
 	cmdCreateFromDebugScript.Flags().BoolVar(&oneshard, "oneshard", false, "If database should be oneshard type")
+	indexesFlag(cmdCreateFromDebugScript)
 }
 
 func createFromDebugScript(cmd *cobra.Command, _ []string) error {
@@ -40,6 +41,11 @@ func createFromDebugScript(cmd *cobra.Command, _ []string) error {
 		return errors.New("file with the count should be provided --countfile")
 	}
 
+	indexes, err := getIndexSpecs(cmd)
+	if err != nil {
+		return err
+	}
+
 	dataFromDebugScript := parser.DatabaseMetaDataFromDebugScript{
 		SizeFileName:  sizeFilename,
 		CountFileName: countFilename,
@@ -57,5 +63,5 @@ func createFromDebugScript(cmd *cobra.Command, _ []string) error {
 		options.Options.Sharding = driver.DatabaseShardingSingle
 	}
 
-	return metadata.CreateDatabases(context.Background(), _client, &options)
+	return metadata.CreateDatabases(context.Background(), _client, &options, indexes)
 }
@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"github.com/arangodb/go-driver"
 	"github.com/neunhoef/collectionmaker/pkg/database"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"strconv"
-	"sync"
 	"time"
 )
 
@@ -44,11 +44,16 @@ type Step struct {
 
 func commonGraphFlags(command *cobra.Command) {
 	var firstTenant, lastTenant, nrPathsPerTenant, parallelism int
+	var vertexCollectionName, edgeCollectionName, graphName string
 
 	command.Flags().IntVar(&firstTenant, "firstTenant", 1, "Index of first tenant to create")
 	command.Flags().IntVar(&lastTenant, "lastTenant", 3000, "Index of last tenant to create")
 	command.Flags().IntVar(&nrPathsPerTenant, "nrPathsPerTenant", 10000, "Number of paths per tenant")
 	command.Flags().IntVar(&parallelism, "parallelism", 4, "Parallelism")
+	command.Flags().StringVar(&vertexCollectionName, "vertex-collection", "instances", "Name of vertex collection")
+	command.Flags().StringVar(&edgeCollectionName, "edge-collection", "steps", "Name of edge collection")
+	command.Flags().StringVar(&graphName, "graph", "G", "Name of smart graph")
+	databaseFlag(command)
 }
 
 func init() {
@@ -56,6 +61,7 @@ func init() {
 
 	cmdCreateGraph.Flags().BoolVar(&drop, "drop", drop, "set -drop to true to drop data before start")
 	commonGraphFlags(cmdCreateGraph)
+	errorBudgetFlags(cmdCreateGraph)
 }
 
 func createGraph(cmd *cobra.Command, _ []string) error {
@@ -64,17 +70,20 @@ func createGraph(cmd *cobra.Command, _ []string) error {
 	lastTenant, _ := cmd.Flags().GetInt("lastTenant")
 	nrPathsPerTenant, _ := cmd.Flags().GetInt("nrPathsPerTenant")
 	parallelism, _ := cmd.Flags().GetInt("parallelism")
+	vertexCollectionName, _ := cmd.Flags().GetString("vertex-collection")
+	edgeCollectionName, _ := cmd.Flags().GetString("edge-collection")
+	graphName, _ := cmd.Flags().GetString("graph")
 
-	db, err := _client.Database(context.Background(), "_system")
+	db, err := getDatabase(cmd)
 	if err != nil {
-		return errors.Wrapf(err, "can not get database: %s", "_system")
+		return err
 	}
 
-	if setup(drop, db) != nil {
-		return errors.Wrapf(err, "setup was already launched")
+	if err := setup(drop, db, graphName, vertexCollectionName, edgeCollectionName); err != nil {
+		return errors.Wrapf(err, "can not set up smart graph")
 	}
 
-	if err := setupSomeTenants(firstTenant, lastTenant, nrPathsPerTenant, parallelism, db); err != nil {
+	if err := setupSomeTenants(firstTenant, lastTenant, nrPathsPerTenant, parallelism, vertexCollectionName, edgeCollectionName, getErrorBudget(cmd), db); err != nil {
 		return errors.Wrapf(err, "can not setup some tenants")
 	}
 
@@ -83,47 +92,34 @@ func createGraph(cmd *cobra.Command, _ []string) error {
 
 // setupSomeTenants creates some tenants in parallel
 func setupSomeTenants(firstTenantNr, lastTenantNr int,
-	nrPathsPerTenant int, parallelism int, db driver.Database) error {
-	wg := sync.WaitGroup{}
-	haveError := false
-	throttle := make(chan int, parallelism)
-	for i := firstTenantNr; i <= lastTenantNr; i++ {
-		i := i // bring into scope
-		wg.Add(1)
-
-		go func(wg *sync.WaitGroup, i int) {
-			defer wg.Done()
-			throttle <- i
-			fmt.Printf("Starting go routine...\n")
-			tenantId := "ten" + strconv.FormatInt(int64(i), 10)
-			err := writeOneTenant(nrPathsPerTenant, tenantId, db)
-			if err != nil {
-				fmt.Printf("setupSomeTenants error: %v\n", err)
-				haveError = true
-			}
-			fmt.Printf("Go routine %d done", <-throttle)
-		}(&wg, i)
-	}
-
-	wg.Wait()
-	if !haveError {
-		return nil
-	}
-	fmt.Printf("Error in setupSomeTenants.\n")
-	return fmt.Errorf("Error in setupSomeTenants.")
+	nrPathsPerTenant int, parallelism int, vertexCollectionName string, edgeCollectionName string,
+	budget runner.ErrorBudget, db driver.Database) error {
+	r := runner.New(runner.Config{
+		Name:        "setupSomeTenants",
+		Parallelism: parallelism,
+		Operation:   "batches",
+		Items:       "paths",
+		Budget:      budget,
+	})
+	_, err := r.RunJobs(firstTenantNr, lastTenantNr, func(ctx context.Context, w *runner.Worker, i int) error {
+		tenantId := "ten" + strconv.FormatInt(int64(i), 10)
+		return writeOneTenant(ctx, w, nrPathsPerTenant, tenantId, vertexCollectionName, edgeCollectionName, db)
+	})
+	return err
 }
 
 // writeOneTenant writes `nrPaths` short paths into the smart graph for
 // tenant with id `tenantId`.
-func writeOneTenant(nrPaths int, tenantId string, db driver.Database) error {
-	instances, err := db.Collection(nil, "instances")
+func writeOneTenant(ctx context.Context, w *runner.Worker, nrPaths int, tenantId string,
+	vertexCollectionName string, edgeCollectionName string, db driver.Database) error {
+	instances, err := db.Collection(ctx, vertexCollectionName)
 	if err != nil {
-		fmt.Printf("writeOneTenant: could not open `instances` collection: %v\n", err)
+		w.Printf("writeOneTenant: could not open `%s` collection: %v\n", vertexCollectionName, err)
 		return err
 	}
-	steps, err := db.Collection(nil, "steps")
+	steps, err := db.Collection(ctx, edgeCollectionName)
 	if err != nil {
-		fmt.Printf("writeOneTenant: could not open `steps` collection: %v\n", err)
+		w.Printf("writeOneTenant: could not open `%s` collection: %v\n", edgeCollectionName, err)
 		return err
 	}
 	ins := make([]Instance, 0, 3000)
@@ -145,51 +141,78 @@ func writeOneTenant(nrPaths int, tenantId string, db driver.Database) error {
 			TenantId: tenantId,
 			Payload:  database.MakeRandomString(1400),
 		}
+		// Smart edge keys have the form <smart value>:<key>:<smart value>:
 		st1 := Step{
+			Key:      tenantId + ":KL" + n + ":" + tenantId,
 			TenantId: tenantId,
-			From:     "instances/" + tenantId + ":K" + n,
-			To:       "instances/" + tenantId + ":L" + n,
+			From:     vertexCollectionName + "/" + tenantId + ":K" + n,
+			To:       vertexCollectionName + "/" + tenantId + ":L" + n,
 			Payload:  database.MakeRandomString(700),
 		}
 		st2 := Step{
+			Key:      tenantId + ":LM" + n + ":" + tenantId,
 			TenantId: tenantId,
-			From:     "instances/" + tenantId + ":L" + n,
-			To:       "instances/" + tenantId + ":M" + n,
+			From:     vertexCollectionName + "/" + tenantId + ":L" + n,
+			To:       vertexCollectionName + "/" + tenantId + ":M" + n,
 			Payload:  database.MakeRandomString(700),
 		}
 		ins = append(ins, in1, in2, in3)
 		sts = append(sts, st1, st2)
 		if len(ins) >= 3000 || i == nrPaths {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
-			_, _, err := instances.CreateDocuments(ctx, ins)
-			if err != nil {
-				fmt.Printf("writeOneTenant: could not write instances: %v\n", err)
-				cancel()
-				return err
-			}
-			_, _, err = steps.CreateDocuments(ctx, sts)
-			if err != nil {
-				fmt.Printf("writeOneTenant: could not write steps: %v\n", err)
-				cancel()
-				return err
-			}
+			start := time.Now()
+			nrBatchPaths := int64(len(sts) / 2)
+			err := writeTenantBatch(ctx, w, instances, steps, ins, sts)
 			ins = ins[0:0]
 			sts = sts[0:0]
-			fmt.Printf("%s Have imported %d paths for tenant %s.\n", time.Now(), i, tenantId)
-			cancel()
+			if err != nil {
+				if err := w.Fail(err); err != nil {
+					return err
+				}
+				continue
+			}
+			w.Record(start, nrBatchPaths)
+			w.Printf("%s Have imported %d paths for tenant %s.\n", time.Now(), i, tenantId)
 		}
 
 	}
 	return nil
 }
 
+// writeTenantBatch writes one batch of vertices and the edges between them.
+// Existing ones with the same keys are replaced, so that create graph can
+// be run again without -drop.
+func writeTenantBatch(ctx context.Context, w *runner.Worker, instances, steps driver.Collection, ins []Instance, sts []Step) error {
+	ctx2, cancel := context.WithTimeout(driver.WithOverwriteMode(ctx, driver.OverwriteModeReplace), time.Hour)
+	defer cancel()
+	_, _, err := instances.CreateDocuments(ctx2, ins)
+	if err != nil {
+		w.Printf("writeOneTenant: could not write instances: %v\n", err)
+		return err
+	}
+	_, _, err = steps.CreateDocuments(ctx2, sts)
+	if err != nil {
+		w.Printf("writeOneTenant: could not write steps: %v\n", err)
+		return err
+	}
+	return nil
+}
+
 // setup will set up a disjoint smart graph, if the smart graph is already
-// there, it will not complain.
-func setup(drop bool, db driver.Database) error {
-	sg, err := db.Graph(nil, "Graph")
+// there with the same definition, it will not complain.
+func setup(drop bool, db driver.Database, graphName string, vertexCollectionName string, edgeCollectionName string) error {
+	edgeDefinition := driver.EdgeDefinition{
+		Collection: edgeCollectionName,
+		From:       []string{vertexCollectionName},
+		To:         []string{vertexCollectionName},
+	}
+	sg, err := db.Graph(nil, graphName)
 	if err == nil {
 		if !drop {
-			fmt.Printf("Found smart graph already, setup is already done.\n")
+			if err := checkGraphDefinition(sg, edgeDefinition, "tenantId"); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return err
+			}
+			fmt.Printf("Found smart graph `%s` already, setup is already done.\n", graphName)
 			return nil
 		}
 		err = sg.Remove(nil)
@@ -197,36 +220,25 @@ func setup(drop bool, db driver.Database) error {
 			fmt.Printf("Could not drop smart graph: %v\n", err)
 			return err
 		}
-		sts, err := db.Collection(nil, "steps")
-		if err != nil {
-			fmt.Printf("Did not find `steps` collection: %v\n", err)
-			return err
-		}
-		if err = sts.Remove(nil); err != nil {
-			fmt.Printf("Could not drop edges: %v\n", err)
-			return err
-		}
-		ins, err := db.Collection(nil, "instances")
-		if err != nil {
-			fmt.Printf("Did not find `instances` collection: %v\n", err)
+	} else if !driver.IsNotFound(err) {
+		fmt.Printf("Error: could not look for smart graph: %v\n", err)
+		return err
+	}
+
+	if drop {
+		// The collections can be left over without the graph, e.g. from a
+		// failed earlier run, so drop them in any case:
+		if err := dropCollectionIfExists(db, edgeCollectionName); err != nil {
 			return err
 		}
-		if err = ins.Remove(nil); err != nil {
-			fmt.Printf("Could not drop vertices: %v\n", err)
+		if err := dropCollectionIfExists(db, vertexCollectionName); err != nil {
 			return err
 		}
-	} else if !driver.IsNotFound(err) {
-		fmt.Printf("Error: could not look for smart graph: %v\n", err)
-		return err
 	}
 
 	// Now create the graph:
-	_, err = db.CreateGraph(nil, "G", &driver.CreateGraphOptions{
-		EdgeDefinitions: []driver.EdgeDefinition{{
-			Collection: "steps",
-			From:       []string{"instances"},
-			To:         []string{"instances"},
-		}},
+	_, err = db.CreateGraph(nil, graphName, &driver.CreateGraphOptions{
+		EdgeDefinitions:     []driver.EdgeDefinition{edgeDefinition},
 		IsSmart:             true,
 		SmartGraphAttribute: "tenantId",
 		NumberOfShards:      3,
@@ -239,3 +251,55 @@ func setup(drop bool, db driver.Database) error {
 	}
 	return nil
 }
+
+// checkGraphDefinition returns an error if the existing graph `g` does not
+// consist of exactly the edge definition `edgeDefinition` with smart graph
+// attribute `smartGraphAttribute`.
+func checkGraphDefinition(g driver.Graph, edgeDefinition driver.EdgeDefinition, smartGraphAttribute string) error {
+	defs := g.EdgeDefinitions()
+	if len(defs) != 1 || defs[0].Collection != edgeDefinition.Collection ||
+		!sameNames(defs[0].From, edgeDefinition.From) || !sameNames(defs[0].To, edgeDefinition.To) {
+		return fmt.Errorf("graph `%s` exists with edge definitions %v instead of %v",
+			g.Name(), defs, []driver.EdgeDefinition{edgeDefinition})
+	}
+	if g.SmartGraphAttribute() != smartGraphAttribute {
+		return fmt.Errorf("graph `%s` exists with smart graph attribute `%s` instead of `%s`",
+			g.Name(), g.SmartGraphAttribute(), smartGraphAttribute)
+	}
+	return nil
+}
+
+// sameNames returns true if `a` and `b` contain the same names in any order.
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	names := make(map[string]int, len(a))
+	for _, name := range a {
+		names[name]++
+	}
+	for _, name := range b {
+		if names[name] == 0 {
+			return false
+		}
+		names[name]--
+	}
+	return true
+}
+
+// dropCollectionIfExists drops the collection `name` if it is there.
+func dropCollectionIfExists(db driver.Database, name string) error {
+	col, err := db.Collection(nil, name)
+	if driver.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		fmt.Printf("Error: could not look for `%s` collection: %v\n", name, err)
+		return err
+	}
+	if err = col.Remove(nil); err != nil {
+		fmt.Printf("Could not drop `%s` collection: %v\n", name, err)
+		return err
+	}
+	return nil
+}
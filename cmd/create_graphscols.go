@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"github.com/arangodb/go-driver"
 	"github.com/pkg/errors"
@@ -20,17 +19,22 @@ func init() {
 	var drop = false
 	var replicationFactor int
 	var numberOfShards int
+	var vertexCollectionName, edgeCollectionName string
 
 	cmdCreateGraphCols.Flags().BoolVar(&drop, "drop", drop, "set -drop to true to drop data before start")
 	cmdCreateGraphCols.Flags().IntVar(&replicationFactor, "replicationFactor", 3, "replication factor for edge collection")
 	cmdCreateGraphCols.Flags().IntVar(&numberOfShards, "numberOfShards", 42, "number of shards of edge collection")
+	cmdCreateGraphCols.Flags().StringVar(&vertexCollectionName, "vertex-collection", "instances", "name of vertex collections, suffixes '' and '2' are appended")
+	cmdCreateGraphCols.Flags().StringVar(&edgeCollectionName, "edge-collection", "steps", "name of edge collections, suffixes '' and '2' are appended")
+	indexesFlag(cmdCreateGraphCols)
+	databaseFlag(cmdCreateGraphCols)
 }
 
 func createGraphCols(cmd *cobra.Command, _ []string) error {
 	drop, _ := cmd.Flags().GetBool("drop")
-	db, err := _client.Database(context.Background(), "_system")
+	db, err := getDatabase(cmd)
 	if err != nil {
-		return errors.Wrapf(err, "can not get database: %s", "_system")
+		return err
 	}
 
 	if err := setupGraphVertexCol(cmd, drop, db, ""); err != nil {
@@ -46,17 +50,24 @@ func createGraphCols(cmd *cobra.Command, _ []string) error {
 		return errors.Wrapf(err, "can not create graph edge collection")
 	}
 
+	vertexCollectionName, _ := cmd.Flags().GetString("vertex-collection")
+	edgeCollectionName, _ := cmd.Flags().GetString("edge-collection")
+	if err := ensureIndexes(cmd, db, vertexCollectionName, edgeCollectionName,
+		vertexCollectionName+"2", edgeCollectionName+"2"); err != nil {
+		return errors.Wrapf(err, "can not create indexes")
+	}
 
 	return nil
 }
 
 // setupGraphVertexCol will set up a single vertex collecion
 func setupGraphVertexCol(cmd *cobra.Command, drop bool, db driver.Database, suffix string) error {
-	name := "instances" + suffix
+	vertexCollectionName, _ := cmd.Flags().GetString("vertex-collection")
+	name := vertexCollectionName + suffix
 	replicationFactor, _ := cmd.Flags().GetInt("replicationFactor")
 	numberOfShards, _ := cmd.Flags().GetInt("numberOfShards")
 
-	ec, err := db.Collection(nil, "instances" + suffix)
+	ec, err := db.Collection(nil, name)
 	if err == nil {
 		if !drop {
 			fmt.Printf("Found vertex collection '%s' already, setup is already done.\n", name)
@@ -87,7 +98,8 @@ func setupGraphVertexCol(cmd *cobra.Command, drop bool, db driver.Database, suff
 
 // setupGraphEdgeCol will set up a single edge collecion
 func setupGraphEdgeCol(cmd *cobra.Command, drop bool, db driver.Database, suffix string) error {
-	name := "steps" + suffix;
+	edgeCollectionName, _ := cmd.Flags().GetString("edge-collection")
+	name := edgeCollectionName + suffix
 	replicationFactor, _ := cmd.Flags().GetInt("replicationFactor")
 	numberOfShards, _ := cmd.Flags().GetInt("numberOfShards")
 
@@ -4,13 +4,12 @@ import (
 	"context"
 	"fmt"
 	"math/bits"
-	"math/rand"
 	"strconv"
-	"sync"
 	"time"
 
 	"github.com/arangodb/go-driver"
 	"github.com/neunhoef/collectionmaker/pkg/database"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -47,12 +46,17 @@ func smartGraphFlags(command *cobra.Command) {
 	var numberOfParts, vertexPayloadLength, edgePayloadLength int
 	var log2NumberOfVertices int
 	var parallelism int
+	var vertexCollectionName, edgeCollectionName, graphName string
 
 	command.Flags().IntVar(&numberOfParts, "numberOfParts", defaultNumberOfParts, "Number of parts of graph to create")
 	command.Flags().IntVar(&vertexPayloadLength, "vertexPayloadLength", defaultVertexPayloadLength, "Size in bytes of payload for vertices")
 	command.Flags().IntVar(&edgePayloadLength, "edgePayloadLength", defaultEdgePayloadLength, "Size in bytes of payload for edges")
 	command.Flags().IntVar(&log2NumberOfVertices, "log2NumberOfVertices", defaultLog2NumberOfVertices, "Log 2 of number of vertices in each part")
 	command.Flags().IntVar(&parallelism, "parallelism", 1, "Number of go routines")
+	command.Flags().StringVar(&vertexCollectionName, "vertex-collection", "vertices", "Name of vertex collection")
+	command.Flags().StringVar(&edgeCollectionName, "edge-collection", "links", "Name of edge collection")
+	command.Flags().StringVar(&graphName, "graph", "SmartGraph", "Name of smart graph")
+	databaseFlag(command)
 }
 
 func init() {
@@ -60,6 +64,7 @@ func init() {
 
 	cmdCreateSmartGraphConnectedComponents.Flags().BoolVar(&drop, "drop", drop, "set -drop to true to drop data before start")
 	smartGraphFlags(cmdCreateSmartGraphConnectedComponents)
+	errorBudgetFlags(cmdCreateSmartGraphConnectedComponents)
 }
 
 func createSmartGraph(cmd *cobra.Command, _ []string) error {
@@ -69,17 +74,21 @@ func createSmartGraph(cmd *cobra.Command, _ []string) error {
 	edgePayloadLength, _ := cmd.Flags().GetInt("edgePayloadLength")
 	log2NumberOfVertices, _ := cmd.Flags().GetInt("log2NumberOfVertices")
 	parallelism, _ := cmd.Flags().GetInt("parallelism")
+	vertexCollectionName, _ := cmd.Flags().GetString("vertex-collection")
+	edgeCollectionName, _ := cmd.Flags().GetString("edge-collection")
+	graphName, _ := cmd.Flags().GetString("graph")
 
-	db, err := _client.Database(context.Background(), "_system")
+	db, err := getDatabase(cmd)
 	if err != nil {
-		return errors.Wrapf(err, "can not get database: %s", "_system")
+		return err
 	}
 
-	if setupSmart(drop, db) != nil {
-		return errors.Wrapf(err, "setup was already launched")
+	if err := setupSmart(drop, db, graphName, vertexCollectionName, edgeCollectionName); err != nil {
+		return errors.Wrapf(err, "can not set up smart graph")
 	}
 
-	if err := setupSomeParts(numberOfParts, vertexPayloadLength, edgePayloadLength, log2NumberOfVertices, parallelism, db); err != nil {
+	if err := setupSomeParts(numberOfParts, vertexPayloadLength, edgePayloadLength, log2NumberOfVertices, parallelism,
+		vertexCollectionName, edgeCollectionName, getErrorBudget(cmd), db); err != nil {
 		return errors.Wrapf(err, "can not setup some parts")
 	}
 
@@ -89,46 +98,34 @@ func createSmartGraph(cmd *cobra.Command, _ []string) error {
 // setupSomeParts creates some parts in parallel
 func setupSomeParts(numberOfParts int, vertexPayloadLength int,
 	edgePayloadLength int, log2NumberOfVertices int, parallelism int,
-	db driver.Database) error {
-	wg := sync.WaitGroup{}
-	haveError := false
-	throttle := make(chan int, parallelism)
-	for i := 1; i <= numberOfParts; i++ {
-		i := i // bring into scope
-		wg.Add(1)
-
-		go func(wg *sync.WaitGroup, i int) {
-			defer wg.Done()
-			throttle <- i
-			fmt.Printf("Starting go routine...\n")
-			partId := strconv.FormatInt(int64(i), 10)
-			err := writeOnePart(partId, vertexPayloadLength, edgePayloadLength, log2NumberOfVertices, db)
-			if err != nil {
-				fmt.Printf("setupSomeParts error: %v\n", err)
-				haveError = true
-			}
-			fmt.Printf("Go routine %d done", <-throttle)
-		}(&wg, i)
-	}
-
-	wg.Wait()
-	if !haveError {
-		return nil
-	}
-	fmt.Printf("Error in setupSomeParts.\n")
-	return fmt.Errorf("Error in setupSomeParts.")
+	vertexCollectionName string, edgeCollectionName string,
+	budget runner.ErrorBudget, db driver.Database) error {
+	r := runner.New(runner.Config{
+		Name:        "setupSomeParts",
+		Parallelism: parallelism,
+		Operation:   "batches",
+		Items:       "docs",
+		Budget:      budget,
+	})
+	_, err := r.RunJobs(1, numberOfParts, func(ctx context.Context, w *runner.Worker, i int) error {
+		partId := strconv.FormatInt(int64(i), 10)
+		return writeOnePart(ctx, w, partId, vertexPayloadLength, edgePayloadLength, log2NumberOfVertices,
+			vertexCollectionName, edgeCollectionName, db)
+	})
+	return err
 }
 
 // writeOnePart writes one part into the smart graph for id `partId`.
-func writeOnePart(partId string, vertexPayloadLength int, edgePayloadLength int, log2NumberOfVertices int, db driver.Database) error {
-	vertices, err := db.Collection(nil, "vertices")
+func writeOnePart(ctx context.Context, w *runner.Worker, partId string, vertexPayloadLength int, edgePayloadLength int, log2NumberOfVertices int,
+	vertexCollectionName string, edgeCollectionName string, db driver.Database) error {
+	vertices, err := db.Collection(ctx, vertexCollectionName)
 	if err != nil {
-		fmt.Printf("writeOnePart: could not open `vertices` collection: %v\n", err)
+		w.Printf("writeOnePart: could not open `%s` collection: %v\n", vertexCollectionName, err)
 		return err
 	}
-	links, err := db.Collection(nil, "links")
+	links, err := db.Collection(ctx, edgeCollectionName)
 	if err != nil {
-		fmt.Printf("writeOnePart: could not open `links` collection: %v\n", err)
+		w.Printf("writeOnePart: could not open `%s` collection: %v\n", edgeCollectionName, err)
 		return err
 	}
 	ver := make([]Vertex, 0, 3000)
@@ -146,16 +143,21 @@ func writeOnePart(partId string, vertexPayloadLength int, edgePayloadLength int,
 		}
 		ver = append(ver, v)
 		if len(ver) >= 3000 || i == nr {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
-			_, _, err := vertices.CreateDocuments(ctx, ver)
+			start := time.Now()
+			nrDocs := int64(len(ver))
+			ctx2, cancel := context.WithTimeout(ctx, time.Hour)
+			_, _, err := vertices.CreateDocuments(ctx2, ver)
+			cancel()
+			ver = ver[0:0]
 			if err != nil {
-				fmt.Printf("writeOnePart: could not write vertices: %v\n", err)
-				cancel()
-				return err
+				w.Printf("writeOnePart: could not write vertices: %v\n", err)
+				if err := w.Fail(err); err != nil {
+					return err
+				}
+				continue
 			}
-			ver = ver[0:0]
-			fmt.Printf("%s Have imported %d vertices for part %s.\n", time.Now(), i, partId)
-			cancel()
+			w.Record(start, nrDocs)
+			w.Printf("%s Have imported %d vertices for part %s.\n", time.Now(), i, partId)
 	  }
   }
 	// Now create two edges for each vertex:
@@ -163,52 +165,66 @@ func writeOnePart(partId string, vertexPayloadLength int, edgePayloadLength int,
 	for i = 1; i <= nr; i++ {
 		comp := bits.TrailingZeros64(uint64(i))
 		tmp := uint64(1) << comp
-		j := ((rand.Uint64() % uint64(nr) + 1) &^ (tmp - 1)) | tmp
+		j := ((w.Rand.Uint64()%uint64(nr) + 1) &^ (tmp - 1)) | tmp
 		li1 := Link{
-			From:     "vertices/" + partId + ":K" + strconv.FormatInt(i, 10),
-			To:       "vertices/" + partId + ":K" + strconv.FormatInt(int64(j), 10),
+			From:    vertexCollectionName + "/" + partId + ":K" + strconv.FormatInt(i, 10),
+			To:      vertexCollectionName + "/" + partId + ":K" + strconv.FormatInt(int64(j), 10),
 			Payload:  database.MakeRandomString(edgePayloadLength),
 		}
 		li1b := Link{
-			From:     "vertices/" + partId + ":K" + strconv.FormatInt(int64(j), 10),
-			To:       "vertices/" + partId + ":K" + strconv.FormatInt(i, 10),
+			From:    vertexCollectionName + "/" + partId + ":K" + strconv.FormatInt(int64(j), 10),
+			To:      vertexCollectionName + "/" + partId + ":K" + strconv.FormatInt(i, 10),
 			Payload:  database.MakeRandomString(edgePayloadLength),
 		}
-		j = ((rand.Uint64() % uint64(nr) + 1) &^ (tmp - 1)) | tmp
+		j = ((w.Rand.Uint64()%uint64(nr) + 1) &^ (tmp - 1)) | tmp
 		li2 := Link{
-			From:     "vertices/" + partId + ":K" + strconv.FormatInt(i, 10),
-			To:       "vertices/" + partId + ":K" + strconv.FormatInt(int64(j), 10),
+			From:    vertexCollectionName + "/" + partId + ":K" + strconv.FormatInt(i, 10),
+			To:      vertexCollectionName + "/" + partId + ":K" + strconv.FormatInt(int64(j), 10),
 			Payload:  database.MakeRandomString(edgePayloadLength),
 		}
 		li2b := Link{
-			From:     "vertices/" + partId + ":K" + strconv.FormatInt(int64(j), 10),
-			To:       "vertices/" + partId + ":K" + strconv.FormatInt(i, 10),
+			From:    vertexCollectionName + "/" + partId + ":K" + strconv.FormatInt(int64(j), 10),
+			To:      vertexCollectionName + "/" + partId + ":K" + strconv.FormatInt(i, 10),
 			Payload:  database.MakeRandomString(edgePayloadLength),
 		}
 		lin = append(lin, li1, li1b, li2, li2b)
 		if len(lin) >= 3000 || i == nr {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
-			_, _, err = links.CreateDocuments(ctx, lin)
+			start := time.Now()
+			nrDocs := int64(len(lin))
+			ctx2, cancel := context.WithTimeout(ctx, time.Hour)
+			_, _, err = links.CreateDocuments(ctx2, lin)
+			cancel()
+			lin = lin[0:0]
 			if err != nil {
-				fmt.Printf("writeOnePart: could not write links: %v\n", err)
-				cancel()
-				return err
+				w.Printf("writeOnePart: could not write links: %v\n", err)
+				if err := w.Fail(err); err != nil {
+					return err
+				}
+				continue
 			}
-			lin = lin[0:0]
-			fmt.Printf("%s Have imported %d links for part %s.\n", time.Now(), 2*i, partId)
-			cancel()
+			w.Record(start, nrDocs)
+			w.Printf("%s Have imported %d links for part %s.\n", time.Now(), 2*i, partId)
 		}
 	}
 	return nil
 }
 
-// setup will set up a disjoint smart graph, if the smart graph is already
-// there, it will not complain.
-func setupSmart(drop bool, db driver.Database) error {
-	sg, err := db.Graph(nil, "SmartGraph")
+// setupSmart will set up a disjoint smart graph, if the smart graph is
+// already there with the same definition, it will not complain.
+func setupSmart(drop bool, db driver.Database, graphName string, vertexCollectionName string, edgeCollectionName string) error {
+	edgeDefinition := driver.EdgeDefinition{
+		Collection: edgeCollectionName,
+		From:       []string{vertexCollectionName},
+		To:         []string{vertexCollectionName},
+	}
+	sg, err := db.Graph(nil, graphName)
 	if err == nil {
 		if !drop {
-			fmt.Printf("Found smart graph already, setup is already done.\n")
+			if err := checkGraphDefinition(sg, edgeDefinition, "smartPart"); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return err
+			}
+			fmt.Printf("Found smart graph `%s` already, setup is already done.\n", graphName)
 			return nil
 		}
 		err = sg.Remove(nil)
@@ -216,36 +232,23 @@ func setupSmart(drop bool, db driver.Database) error {
 			fmt.Printf("Could not drop smart graph: %v\n", err)
 			return err
 		}
-		sts, err := db.Collection(nil, "links")
-		if err != nil {
-			fmt.Printf("Did not find `links` collection: %v\n", err)
-			return err
-		}
-		if err = sts.Remove(nil); err != nil {
-			fmt.Printf("Could not drop edges: %v\n", err)
-			return err
-		}
-		ins, err := db.Collection(nil, "vertices")
-		if err != nil {
-			fmt.Printf("Did not find `vertices` collection: %v\n", err)
+	} else if !driver.IsNotFound(err) {
+		fmt.Printf("Error: could not look for smart graph: %v\n", err)
+		return err
+	}
+
+	if drop {
+		if err := dropCollectionIfExists(db, edgeCollectionName); err != nil {
 			return err
 		}
-		if err = ins.Remove(nil); err != nil {
-			fmt.Printf("Could not drop vertices: %v\n", err)
+		if err := dropCollectionIfExists(db, vertexCollectionName); err != nil {
 			return err
 		}
-	} else if !driver.IsNotFound(err) {
-		fmt.Printf("Error: could not look for smart graph: %v\n", err)
-		return err
 	}
 
 	// Now create the graph:
-	_, err = db.CreateGraph(nil, "SmartGraph", &driver.CreateGraphOptions{
-		EdgeDefinitions: []driver.EdgeDefinition{{
-			Collection: "links",
-			From:       []string{"vertices"},
-			To:         []string{"vertices"},
-		}},
+	_, err = db.CreateGraph(nil, graphName, &driver.CreateGraphOptions{
+		EdgeDefinitions:     []driver.EdgeDefinition{edgeDefinition},
 		IsSmart:             true,
 		SmartGraphAttribute: "smartPart",
 		NumberOfShards:      3,
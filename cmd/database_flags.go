@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"context"
+	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/database"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// databaseFlag adds the flag for the database a command works on.
+func databaseFlag(command *cobra.Command) {
+	var DBName string
+
+	command.Flags().StringVar(&DBName, "database", "_system", "Name of database which should be used")
+}
+
+// getDatabase returns the database chosen with the flag added by
+// databaseFlag. The database is created if it does not exist yet.
+func getDatabase(cmd *cobra.Command) (driver.Database, error) {
+	DBName, _ := cmd.Flags().GetString("database")
+
+	db, err := database.CreateOrGetDatabase(context.Background(), _client, DBName, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not create/get database: %s", DBName)
+	}
+
+	return db, nil
+}
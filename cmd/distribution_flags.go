@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"github.com/neunhoef/collectionmaker/pkg/distribution"
+	"github.com/spf13/cobra"
+)
+
+// keyDistributionFlags adds the flags which choose how keys are picked.
+func keyDistributionFlags(command *cobra.Command, defaultName string) {
+	var name string
+	var skew, hotSetFraction, hotOperationFraction float64
+
+	command.Flags().StringVar(&name, "key-distribution", defaultName,
+		"Key distribution: uniform, zipfian, latest or hotspot")
+	command.Flags().Float64Var(&skew, "zipfian-skew", distribution.DefaultZipfianSkew,
+		"Skew of the zipfian and latest key distributions, between 0 and 1")
+	command.Flags().Float64Var(&hotSetFraction, "hot-set-fraction", distribution.DefaultHotSetFraction,
+		"Fraction of the keys which are hot for the hotspot key distribution")
+	command.Flags().Float64Var(&hotOperationFraction, "hot-operation-fraction", distribution.DefaultHotOperationFraction,
+		"Fraction of the operations which access the hot keys for the hotspot key distribution")
+}
+
+// getKeyDistribution reads the flags added by keyDistributionFlags.
+func getKeyDistribution(cmd *cobra.Command) distribution.Config {
+	name, _ := cmd.Flags().GetString("key-distribution")
+	skew, _ := cmd.Flags().GetFloat64("zipfian-skew")
+	hotSetFraction, _ := cmd.Flags().GetFloat64("hot-set-fraction")
+	hotOperationFraction, _ := cmd.Flags().GetFloat64("hot-operation-fraction")
+	return distribution.Config{
+		Name:                 name,
+		Skew:                 skew,
+		HotSetFraction:       hotSetFraction,
+		HotOperationFraction: hotOperationFraction,
+	}
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/arangodb/go-driver"
+	"github.com/spf13/cobra"
+	"sync/atomic"
+)
+
+// edgeWriteModes are the ways write edges submits a batch of edges.
+var edgeWriteModes = []string{"documents", "js-transaction", "aql-insert", "aql-upsert"}
+
+// jsTransactionInsert inserts the edges params[1] into the collection
+// params[0] with overwrite mode params[2] and returns the number of edges
+// whose key existed. Any other error aborts the transaction.
+const jsTransactionInsert = `function (params) {
+  const db = require("@arangodb").db;
+  const res = db._collection(params[0]).insert(params[1], { overwriteMode: params[2] });
+  let existing = 0;
+  res.forEach(function (r) {
+    if (r.error) {
+      if (r.errorNum !== 1210) {
+        throw r;
+      }
+      existing++;
+    }
+  });
+  return existing;
+}`
+
+// aqlInsert inserts a batch of edges with the overwrite mode, with
+// overwrite mode conflict (and ignore, see insertMode) existing keys are
+// counted as ignored writes.
+const aqlInsert = `FOR d IN @docs INSERT d INTO @@col OPTIONS { overwriteMode: @mode, ignoreErrors: @ignoreErrors }`
+
+// aqlUpserts are the AQL UPSERT queries by overwrite mode. An UPSERT
+// always writes existing documents, so there is none for ignore and
+// conflict.
+var aqlUpserts = map[driver.OverwriteMode]string{
+	driver.OverwriteModeReplace: `FOR d IN @docs UPSERT { _key: d._key } INSERT d REPLACE d IN @@col`,
+	driver.OverwriteModeUpdate:  `FOR d IN @docs UPSERT { _key: d._key } INSERT d UPDATE d IN @@col`,
+}
+
+// writeModeFlag adds the flag for how a batch of edges is submitted.
+func writeModeFlag(command *cobra.Command) {
+	var mode string
+
+	command.Flags().StringVar(&mode, "write-mode", "documents",
+		"How a batch is written: 'documents' (multi-document insert), 'js-transaction', 'aql-insert' or 'aql-upsert'")
+}
+
+// getWriteMode reads the flag added by writeModeFlag, aql-upsert has no
+// equivalent of overwrite modes ignore and conflict.
+func getWriteMode(cmd *cobra.Command, overwriteMode driver.OverwriteMode) (string, error) {
+	mode, _ := cmd.Flags().GetString("write-mode")
+	for _, m := range edgeWriteModes {
+		if m == mode {
+			if _, ok := aqlUpserts[overwriteMode]; mode == "aql-upsert" && !ok {
+				return "", fmt.Errorf("write mode aql-upsert does not support overwrite mode %s", overwriteMode)
+			}
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("invalid write mode: %s", mode)
+}
+
+// writeBatch writes `eds` with the write mode of the writer and returns the
+// number of edges written.
+func (e *edgeWriter) writeBatch(ctx context.Context, db driver.Database, edges driver.Collection, eds []Edge) (int64, error) {
+	switch e.writeMode {
+	case "js-transaction":
+		return e.writeJSTransaction(ctx, db, eds)
+	case "aql-insert":
+		ignoreErrors := e.insertMode() == driver.OverwriteModeConflict
+		return e.writeQuery(ctx, db, aqlInsert, eds, map[string]interface{}{
+			"mode":         string(e.insertMode()),
+			"ignoreErrors": ignoreErrors,
+		})
+	case "aql-upsert":
+		return e.writeQuery(ctx, db, aqlUpserts[e.overwriteMode], eds, map[string]interface{}{})
+	}
+	return e.createEdges(ctx, edges, eds)
+}
+
+// writeJSTransaction inserts `eds` in a JavaScript transaction.
+func (e *edgeWriter) writeJSTransaction(ctx context.Context, db driver.Database, eds []Edge) (int64, error) {
+	result, err := db.Transaction(ctx, jsTransactionInsert, &driver.TransactionOptions{
+		WriteCollections: []string{e.collectionName},
+		Params:           []interface{}{e.collectionName, eds, string(e.insertMode())},
+	})
+	if err != nil {
+		return 0, err
+	}
+	existing, ok := result.(float64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected result of transaction: %v", result)
+	}
+	atomic.AddInt64(&e.existing, int64(existing))
+	return int64(len(eds)) - int64(existing), nil
+}
+
+// writeQuery writes `eds` with an AQL query and returns the number of
+// writes executed, ignored writes are counted as existing edges.
+func (e *edgeWriter) writeQuery(ctx context.Context, db driver.Database, query string, eds []Edge, bindVars map[string]interface{}) (int64, error) {
+	bindVars["docs"] = eds
+	bindVars["@col"] = e.collectionName
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+	stats := cursor.Statistics()
+	atomic.AddInt64(&e.existing, stats.WritesIgnored())
+	return stats.WritesExecuted(), nil
+}
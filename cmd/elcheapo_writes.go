@@ -4,12 +4,15 @@ import (
 	"context"
 	"fmt"
 	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/database"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"math/rand"
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,142 +24,374 @@ var (
 	}
 )
 
+// errLockTimeout is the error number of ArangoDB for a lock timeout.
+const errLockTimeout = 18
+
+// elCheapoTransactions describes the stream transactions of write elcheapo
+// and counts their outcomes, the counters are accessed atomically.
+type elCheapoTransactions struct {
+	collections      []string // the edges are distributed round robin
+	operations       int      // number of inserts each transaction is split into
+	readOwnWrites    bool
+	abortProbability float64
+	overlap          float64 // fraction of edges with one of the hot keys
+	hotKeys          int
+	options          driver.BeginTransactionOptions
+	commits          int64
+	aborts           int64 // aborted on purpose
+	conflicts        int64 // failed with a write-write conflict
+	lockTimeouts     int64
+	mutex            sync.Mutex
+	phases           map[string]*runner.Stats // latencies of begin, insert, read, commit and abort
+}
+
+// elCheapoPhases are the phases of a transaction in the order of reporting.
+var elCheapoPhases = []string{"begin", "insert", "read", "commit", "abort"}
+
 func init() {
 	var parallelism int = 1
 	var number int64 = 1000000
+	var collectionName string = "edges"
+	var vertexCollectionName string = "pubmed"
+	var keyPrefix string = "w"
+	var seed int64 = 0
+	var transactionSize int64 = 1000
+	var operations int = 1
+	var collections int = 1
+	var readOwnWrites bool = false
+	var abortProbability float64 = 0
+	var lockTimeout float64 = 0
+	var overlap float64 = 0
+	var hotKeys int = 100
 	cmdElCheapoWrites.Flags().IntVar(&parallelism, "parallelism", parallelism, "set -parallelism to use multiple go routines")
 	cmdElCheapoWrites.Flags().Int64Var(&number, "number", number, "set -number for number of edges to write per go routine")
+	cmdElCheapoWrites.Flags().StringVar(&collectionName, "collection", collectionName, "Name of edge collection.")
+	cmdElCheapoWrites.Flags().StringVar(&vertexCollectionName, "vertex-collection", vertexCollectionName, "Name of vertex collection the edges point to.")
+	cmdElCheapoWrites.Flags().StringVar(&keyPrefix, "key-prefix", keyPrefix, "Prefix of the edge keys, which are <prefix><go routine>_<number>")
+	cmdElCheapoWrites.Flags().Int64Var(&seed, "seed", seed, "Seed of the random edges, the same seed writes the same edges again")
+	cmdElCheapoWrites.Flags().Int64Var(&transactionSize, "transaction-size", transactionSize, "Number of edges written per stream transaction.")
+	cmdElCheapoWrites.Flags().IntVar(&operations, "operations", operations, "Number of inserts each transaction is split into.")
+	cmdElCheapoWrites.Flags().IntVar(&collections, "collections", collections, "Number of edge collections written by each transaction, suffixes '', '2', '3', ... are appended to the collection name")
+	cmdElCheapoWrites.Flags().BoolVar(&readOwnWrites, "read-own-writes", readOwnWrites, "set -read-own-writes to read back the edges in the transaction before committing")
+	cmdElCheapoWrites.Flags().Float64Var(&abortProbability, "abort-probability", abortProbability, "Probability that a transaction is aborted instead of committed")
+	cmdElCheapoWrites.Flags().Float64Var(&lockTimeout, "lock-timeout", lockTimeout, "Lock timeout of the transactions in seconds, 0 for the server default")
+	cmdElCheapoWrites.Flags().Float64Var(&overlap, "overlap", overlap, "Fraction of edges which get one of the hot keys shared by all go routines, to provoke conflicts")
+	cmdElCheapoWrites.Flags().IntVar(&hotKeys, "hot-keys", hotKeys, "Number of hot keys for -overlap")
+	overwriteModeFlag(cmdElCheapoWrites, driver.OverwriteModeConflict)
+	uidRangeFlags(cmdElCheapoWrites)
+	createVerticesFlags(cmdElCheapoWrites)
+	errorBudgetFlags(cmdElCheapoWrites)
+	databaseFlag(cmdElCheapoWrites)
 }
 
 // writeEdges writes edges in parallel
 func writeEdgesElCheapo(cmd *cobra.Command, _ []string) error {
 	parallelism, _ := cmd.Flags().GetInt("parallelism")
 	number, _ := cmd.Flags().GetInt64("number")
+	writer, err := newEdgeWriter(cmd)
+	if err != nil {
+		return err
+	}
+	writer.batchSize, _ = cmd.Flags().GetInt64("transaction-size")
+	if writer.batchSize < 1 {
+		return fmt.Errorf("transaction size must be positive: %d", writer.batchSize)
+	}
 
-	db, err := _client.Database(context.Background(), "_system")
+	txns, err := newElCheapoTransactions(cmd, writer.collectionName)
 	if err != nil {
-		return errors.Wrapf(err, "can not get database: %s", "_system")
+		return err
 	}
 
-	if err := writeSomeEdgesParallelElCheapo(parallelism, number, db); err != nil {
+	db, err := getDatabase(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := setupVertices(cmd, db, writer.vertexCollectionName, writer.collectionName); err != nil {
+		return errors.Wrapf(err, "can not create vertices")
+	}
+	// The additional collections are created like create edgecol would:
+	for _, name := range txns.collections[1:] {
+		if _, err := database.CreateOrGetCollection(context.Background(), db, name, &driver.CreateCollectionOptions{
+			Type: driver.CollectionTypeEdge,
+		}); err != nil {
+			return errors.Wrapf(err, "can not create/get collection: %s", name)
+		}
+	}
+
+	err = writeSomeEdgesParallelElCheapo(parallelism, number, writer, txns, getErrorBudget(cmd), db)
+	writer.printExisting()
+	txns.print()
+	if err != nil {
 		return errors.Wrapf(err, "can not setup some tenants")
 	}
 
 	return nil
 }
 
-// writeSomeEdges creates some edges in parallel
-func writeSomeEdgesParallelElCheapo(parallelism int, number int64, db driver.Database) error {
-	var mutex sync.Mutex
-	totaltimestart := time.Now()
-	wg := sync.WaitGroup{}
-	haveError := false
-	for i := 1; i <= parallelism; i++ {
-	  time.Sleep(5 * time.Millisecond)
-		i := i // bring into scope
-		wg.Add(1)
-
-		go func(wg *sync.WaitGroup, i int) {
-			defer wg.Done()
-			fmt.Printf("Starting go routine...\n")
-			id := "id_" + strconv.FormatInt(int64(i), 10)
-			err := writeSomeEdgesElCheapo(number, id, db, &mutex)
-			if err != nil {
-				fmt.Printf("writeSomeEdgesElCheapo error: %v\n", err)
-				haveError = true
-			}
-			mutex.Lock()
-			fmt.Printf("Go routine %d done\n", i)
-			mutex.Unlock()
-		}(&wg, i)
-	}
-
-	wg.Wait()
-	totaltimeend := time.Now()
-	totaltime := totaltimeend.Sub(totaltimestart)
-	docspersec := float64(int64(parallelism) * number) / (float64(totaltime) / float64(time.Second))
-	fmt.Printf("\nTotal number of edges written: %d, total time: %v, total edges per second: %f\n", int64(parallelism) * number, totaltimeend.Sub(totaltimestart), docspersec)
-	if !haveError {
-		return nil
+// newElCheapoTransactions reads the flags which vary the transactions.
+func newElCheapoTransactions(cmd *cobra.Command, collectionName string) (*elCheapoTransactions, error) {
+	t := &elCheapoTransactions{phases: make(map[string]*runner.Stats)}
+	collections, _ := cmd.Flags().GetInt("collections")
+	lockTimeout, _ := cmd.Flags().GetFloat64("lock-timeout")
+	t.operations, _ = cmd.Flags().GetInt("operations")
+	t.readOwnWrites, _ = cmd.Flags().GetBool("read-own-writes")
+	t.abortProbability, _ = cmd.Flags().GetFloat64("abort-probability")
+	t.overlap, _ = cmd.Flags().GetFloat64("overlap")
+	t.hotKeys, _ = cmd.Flags().GetInt("hot-keys")
+	if collections < 1 || t.operations < 1 || t.hotKeys < 1 {
+		return nil, fmt.Errorf("collections, operations and hot keys must be positive")
+	}
+	if t.abortProbability < 0 || t.abortProbability > 1 || t.overlap < 0 || t.overlap > 1 {
+		return nil, fmt.Errorf("abort probability and overlap must be between 0 and 1")
+	}
+	t.collections = []string{collectionName}
+	for i := 2; i <= collections; i++ {
+		t.collections = append(t.collections, collectionName+strconv.Itoa(i))
 	}
-	fmt.Printf("Error in writeSomeEdges.\n")
-	return fmt.Errorf("Error in writeSomeEdges.")
+	t.options.LockTimeout = time.Duration(lockTimeout * float64(time.Second))
+	return t, nil
 }
 
-// writeOneTenant writes `nrPaths` short paths into the smart graph for
-// tenant with id `tenantId`.
-func writeSomeEdgesElCheapo(nrEdges int64, id string, db driver.Database, mutex *sync.Mutex) error {
-	edges, err := db.Collection(nil, "edges")
-	if err != nil {
-		fmt.Printf("writeSomeEdgesElCheapo: could not open `edges` collection: %v\n", err)
-		return err
-	}
-	eds := make([]Edge, 0, 1000)
-  times := make([]time.Duration, 0, 1000)
-	cyclestart := time.Now()
-	tcolls := driver.TransactionCollections{
-		Write: []string{"edges"},
+// record adds the latency of a phase of a transaction.
+func (t *elCheapoTransactions) record(phase string, start time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.phases[phase] == nil {
+		t.phases[phase] = runner.NewStats()
 	}
-	topts := driver.BeginTransactionOptions{
+	t.phases[phase].Add(time.Since(start), 1)
+}
+
+// print prints the outcomes of the transactions and the latencies of
+// their phases.
+func (t *elCheapoTransactions) print() {
+	fmt.Printf("Transactions: %d committed, %d aborted on purpose, %d write-write conflicts, %d lock timeouts\n",
+		t.commits, t.aborts, t.conflicts, t.lockTimeouts)
+	for _, phase := range elCheapoPhases {
+		if s := t.phases[phase]; s != nil {
+			fmt.Printf("Times for %d %s: %s\n", s.Count(), phase, s.Latencies())
+		}
 	}
-	for i := int64(1); i <= nrEdges / 1000; i++ {
-		start := time.Now()
-    for j := 1; j <= 1000; j++ {
-			fromUid := rand.Intn(10000)
-			toUid := rand.Intn(10000)
-			eds = append(eds, Edge{
-					From: "pubmed/U" + strconv.FormatInt(int64(fromUid), 10),
-					To: "pubmed/U" + strconv.FormatInt(int64(toUid), 10),
-					FromUid: fromUid,
-					ToUid: toUid,
-          Score: rand.Intn(10000000),
-					Last_modified: time.Now().Format(time.RFC3339),
-			})
-	  }
-		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
-		tid, err := db.BeginTransaction(ctx, tcolls, &topts)
+}
+
+// writeSomeEdgesParallelElCheapo creates some edges in parallel
+func writeSomeEdgesParallelElCheapo(parallelism int, number int64, writer *edgeWriter, txns *elCheapoTransactions, budget runner.ErrorBudget, db driver.Database) error {
+	r := runner.New(runner.Config{
+		Name:          "writeSomeEdgesElCheapo",
+		Parallelism:   parallelism,
+		FirstID:       1,
+		StartDelay:    5 * time.Millisecond,
+		Operation:     "transactions",
+		Items:         "edges",
+		ReportEvery:   100,
+		Budget:        budget,
+		Deterministic: true,
+		Seed:          writer.seed,
+	})
+	_, err := r.Run(func(ctx context.Context, w *runner.Worker) error {
+		return writeSomeEdgesElCheapo(ctx, w, number, writer, txns, db)
+	})
+	return err
+}
+
+// writeSomeEdgesElCheapo writes `nrEdges` random edges, as many in each
+// stream transaction as the batch size of the writer. Only committed
+// transactions are recorded.
+func writeSomeEdgesElCheapo(ctx context.Context, w *runner.Worker, nrEdges int64, writer *edgeWriter, txns *elCheapoTransactions, db driver.Database) error {
+	colls := make([]driver.Collection, len(txns.collections))
+	for i, name := range txns.collections {
+		coll, err := db.Collection(ctx, name)
 		if err != nil {
-			cancel()
-			fmt.Printf("writeSomeEdgesElCheapo: could not begin transaction: %v\n", err)
+			w.Printf("writeSomeEdgesElCheapo: could not open `%s` collection: %v\n", name, err)
 			return err
 		}
-    ctx2 := driver.WithTransactionID(ctx, tid)
-		_, _, err = edges.CreateDocuments(ctx2, eds)
-		if err != nil {
-			_ = db.AbortTransaction(ctx, tid, &driver.AbortTransactionOptions{})
-			cancel()
-			fmt.Printf("writeSomeEdgesElCheapo: could not write edges: %v\n", err)
-			return err
+		colls[i] = coll
+	}
+	id := "id_" + strconv.FormatInt(int64(w.ID), 10)
+	eds := make([]Edge, 0, writer.batchSize)
+	tcolls := driver.TransactionCollections{
+		Write: txns.collections,
+	}
+	// The edges come from w.Rand, the transactions are varied with another
+	// source so that the edges are the same as without variations:
+	source := rand.New(rand.NewSource(int64(w.ID) + rand.Int63()))
+	var written int64
+	for i, done := int64(1), int64(0); done < nrEdges && ctx.Err() == nil; i++ {
+		start := time.Now()
+		n := writer.nextBatch(done, nrEdges)
+		for j := int64(0); j < n; j++ {
+			edge := writer.makeEdge(w, done+j)
+			if txns.overlap > 0 && source.Float64() < txns.overlap {
+				edge.Key = writer.keyPrefix + "hot" + strconv.Itoa(source.Intn(txns.hotKeys))
+			}
+			eds = append(eds, edge)
 		}
-		err = db.CommitTransaction(ctx, tid, &driver.CommitTransactionOptions{})
-		cancel()
+		done += n
+		nr, committed, err := txns.run(ctx, w, source, db, writer, colls, tcolls, eds)
+		eds = eds[0:0]
 		if err != nil {
-			fmt.Printf("writeSomeEdgesElCheap: could not commit transaction: %v\n", err)
-			return err
+			if err := w.Fail(err); err != nil {
+				return err
+			}
+			continue
 		}
-
-		cancel()
-		eds = eds[0:0]
+		if !committed {
+			continue
+		}
+		written += nr
 		if i % 100 == 0 {
-			mutex.Lock()
-			fmt.Printf("%s Have imported %d paths for id %s.\n", time.Now(), i * 1000, id)
-			mutex.Unlock()
-			cancel()
+			w.Printf("%s Have imported %d edges for id %s.\n", time.Now(), written, id)
 		}
-    times = append(times, time.Now().Sub(start))
-		if len(times) % 100 == 0 {
-			sort.Sort(DurationSlice(times))
-			var sum int64 = 0
-			for _, t := range times {
-				sum = sum + int64(t)
+		w.Record(start, nr)
+	}
+	return nil
+}
+
+// countFailure counts a write-write conflict or a lock timeout and returns
+// true, other errors are not counted.
+func (t *elCheapoTransactions) countFailure(err error) bool {
+	if driver.IsArangoErrorWithErrorNum(err, driver.ErrArangoConflict) {
+		atomic.AddInt64(&t.conflicts, 1)
+		return true
+	}
+	if driver.IsArangoErrorWithErrorNum(err, errLockTimeout) {
+		atomic.AddInt64(&t.lockTimeouts, 1)
+		return true
+	}
+	return false
+}
+
+// run writes `eds` in one stream transaction, split into the operations
+// and distributed round robin over the collections, optionally reads them
+// back and commits or aborts. It returns the number of edges written and
+// whether the transaction was committed. Conflicts and lock timeouts abort
+// the transaction and are counted instead of returned.
+func (t *elCheapoTransactions) run(ctx context.Context, w *runner.Worker, source *rand.Rand, db driver.Database, writer *edgeWriter,
+	colls []driver.Collection, tcolls driver.TransactionCollections, eds []Edge) (int64, bool, error) {
+	ctx2, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+	start := time.Now()
+	tid, err := db.BeginTransaction(ctx2, tcolls, &t.options)
+	if err != nil {
+		if t.countFailure(err) {
+			return 0, false, nil
+		}
+		w.Printf("writeSomeEdgesElCheapo: could not begin transaction: %v\n", err)
+		return 0, false, err
+	}
+	t.record("begin", start)
+	ctx3 := driver.WithTransactionID(ctx2, tid)
+	abort := func() {
+		start := time.Now()
+		_ = db.AbortTransaction(ctx2, tid, &driver.AbortTransactionOptions{})
+		t.record("abort", start)
+	}
+	fail := func(err error, format string) (int64, bool, error) {
+		abort()
+		if t.countFailure(err) {
+			return 0, false, nil
+		}
+		w.Printf(format, err)
+		return 0, false, err
+	}
+
+	// The last edge written for each key of each collection:
+	expected := make([]map[string]Edge, len(colls))
+	for c := range colls {
+		expected[c] = make(map[string]Edge)
+	}
+	var nr, existing int64
+	chunk := (len(eds) + t.operations - 1) / t.operations
+	for first := 0; first < len(eds); first += chunk {
+		last := first + chunk
+		if last > len(eds) {
+			last = len(eds)
+		}
+		for c, coll := range colls {
+			var part []Edge
+			for j := first; j < last; j++ {
+				if j%len(colls) == c {
+					part = append(part, eds[j])
+				}
+			}
+			if len(part) == 0 {
+				continue
+			}
+			start := time.Now()
+			_, errs, err := coll.CreateDocuments(driver.WithOverwriteMode(ctx3, writer.insertMode()), part)
+			t.record("insert", start)
+			if err == nil {
+				for k, e := range errs {
+					if e == nil {
+						expected[c][part[k].Key] = part[k]
+						nr++
+					} else if driver.IsArangoErrorWithErrorNum(e, driver.ErrArangoUniqueConstraintViolated) {
+						existing++
+					} else if err == nil {
+						err = e
+					}
+				}
 			}
-			totaltime := time.Now().Sub(cyclestart)
-			docspersec := 100000.0 / (float64(totaltime) / float64(time.Second))
-			mutex.Lock()
-			fmt.Printf("Times for last 100 writes (=100000 edges): %s (median), %s (90%%ile), %s (99%%ilie), %s (average), edges per second in this go routine: %f\n", times[50], times[90], times[99], time.Duration(sum/100), docspersec)
-			mutex.Unlock()
-			times = times[0:0]
-			cyclestart = time.Now()
+			if err != nil {
+				return fail(err, "writeSomeEdgesElCheapo: could not write edges: %v\n")
+			}
+		}
+	}
+
+	if t.readOwnWrites {
+		for c, coll := range colls {
+			if err := t.readBack(ctx3, coll, expected[c]); err != nil {
+				return fail(err, "writeSomeEdgesElCheapo: could not read own writes: %v\n")
+			}
+		}
+	}
+
+	if t.abortProbability > 0 && source.Float64() < t.abortProbability {
+		abort()
+		atomic.AddInt64(&t.aborts, 1)
+		return 0, false, nil
+	}
+	start = time.Now()
+	err = db.CommitTransaction(ctx2, tid, &driver.CommitTransactionOptions{})
+	t.record("commit", start)
+	if err != nil {
+		if t.countFailure(err) {
+			return 0, false, nil
+		}
+		w.Printf("writeSomeEdgesElCheapo: could not commit transaction: %v\n", err)
+		return 0, false, err
+	}
+	atomic.AddInt64(&t.commits, 1)
+	atomic.AddInt64(&writer.existing, existing)
+	return nr, true, nil
+}
+
+// readBack reads the edges written in the transaction and compares them
+// with `expected`.
+func (t *elCheapoTransactions) readBack(ctx context.Context, coll driver.Collection, expected map[string]Edge) error {
+	if len(expected) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(expected))
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	docs := make([]Edge, len(keys))
+	start := time.Now()
+	_, errs, err := coll.ReadDocuments(ctx, keys, docs)
+	t.record("read", start)
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if errs[i] != nil {
+			return errors.Wrapf(runner.ErrWrongResult, "edge %s written in the transaction not found: %v", key, errs[i])
+		}
+		e := expected[key]
+		if docs[i].From != e.From || docs[i].To != e.To || docs[i].Score != e.Score {
+			return errors.Wrapf(runner.ErrWrongResult, "edge %s read in the transaction differs from the one written", key)
 		}
 	}
 	return nil
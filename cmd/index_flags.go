@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"context"
+	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/database"
+	"github.com/spf13/cobra"
+)
+
+// indexesFlag adds the flag for a file with indexes to create.
+func indexesFlag(command *cobra.Command) {
+	var indexes string
+
+	command.Flags().StringVar(&indexes, "indexes", "",
+		"YAML file with a list of indexes to create on the collections")
+}
+
+// getIndexSpecs reads the file given with the flag added by indexesFlag,
+// no file means no indexes.
+func getIndexSpecs(cmd *cobra.Command) ([]database.IndexSpec, error) {
+	filename, _ := cmd.Flags().GetString("indexes")
+	if filename == "" {
+		return nil, nil
+	}
+	return database.ReadIndexSpecs(filename)
+}
+
+// ensureIndexes creates the indexes of the file given with the flag added
+// by indexesFlag on the collections.
+func ensureIndexes(cmd *cobra.Command, db driver.Database, collectionNames ...string) error {
+	specs, err := getIndexSpecs(cmd)
+	if err != nil {
+		return err
+	}
+	for _, name := range collectionNames {
+		if err := database.EnsureIndexes(context.Background(), _client.Connection(), db, name, specs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/arangodb/go-driver"
+	"github.com/spf13/cobra"
+)
+
+// overwriteModeFlag adds the flag for what an insert does with a document
+// whose key exists already.
+func overwriteModeFlag(command *cobra.Command, defaultMode driver.OverwriteMode) {
+	var mode string
+
+	command.Flags().StringVar(&mode, "overwrite-mode", string(defaultMode),
+		"What an insert does if the key exists: ignore, replace, update or conflict")
+}
+
+// getOverwriteMode reads the flag added by overwriteModeFlag.
+func getOverwriteMode(cmd *cobra.Command) (driver.OverwriteMode, error) {
+	mode, _ := cmd.Flags().GetString("overwrite-mode")
+	switch driver.OverwriteMode(mode) {
+	case driver.OverwriteModeIgnore, driver.OverwriteModeReplace, driver.OverwriteModeUpdate, driver.OverwriteModeConflict:
+		return driver.OverwriteMode(mode), nil
+	}
+	return "", fmt.Errorf("invalid overwrite mode: %s", mode)
+}
+
+// countDocumentErrors counts the documents of a multi-document operation
+// which failed because their key exists, which is expected with overwrite
+// mode conflict. The first other error, e.g. a write-write conflict, is
+// returned.
+func countDocumentErrors(errs driver.ErrorSlice) (int64, error) {
+	var conflicts int64
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !driver.IsArangoErrorWithErrorNum(err, driver.ErrArangoUniqueConstraintViolated) {
+			return conflicts, err
+		}
+		conflicts++
+	}
+	return conflicts, nil
+}
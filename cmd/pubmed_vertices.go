@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/database"
+	"github.com/spf13/cobra"
+	"strconv"
+	"time"
+)
+
+// PubmedVertex is a vertex U<uid> the edges of write edges point to.
+type PubmedVertex struct {
+	Key string `json:"_key"`
+	Uid int    `json:"uid"`
+}
+
+// uidRangeFlags adds the flags for the range of uids of the vertices the
+// edges point to.
+func uidRangeFlags(command *cobra.Command) {
+	var firstUid, uids int
+
+	command.Flags().IntVar(&firstUid, "first-uid", 0, "Smallest uid of the vertices the edges point to")
+	command.Flags().IntVar(&uids, "uids", 10000, "Number of vertices U<first-uid>, U<first-uid+1>, ... the edges point to")
+}
+
+// getUidRange reads the flags added by uidRangeFlags.
+func getUidRange(cmd *cobra.Command) (int, int, error) {
+	firstUid, _ := cmd.Flags().GetInt("first-uid")
+	uids, _ := cmd.Flags().GetInt("uids")
+	if firstUid < 0 || uids < 1 {
+		return 0, 0, fmt.Errorf("invalid uid range: %d vertices from %d", uids, firstUid)
+	}
+	return firstUid, uids, nil
+}
+
+// createVerticesFlags adds the flags to create the vertices the edges point
+// to and a named graph over the edges.
+func createVerticesFlags(command *cobra.Command) {
+	var createVertices bool
+	var graphName string
+
+	command.Flags().BoolVar(&createVertices, "create-vertices", false,
+		"set -create-vertices to create the vertices of the uid range and a named graph before writing edges")
+	command.Flags().StringVar(&graphName, "graph", "pubmed_graph", "Name of the named graph created with -create-vertices, empty for none")
+}
+
+// uidVertex returns the _id of the vertex with the uid.
+func uidVertex(vertexCollectionName string, uid int) string {
+	return vertexCollectionName + "/U" + strconv.Itoa(uid)
+}
+
+// setupVertices creates the vertices of the uid range and the named graph
+// `graphName` over the edge collection if -create-vertices is set. Existing
+// vertices are left alone, so that it can be done before every run.
+func setupVertices(cmd *cobra.Command, db driver.Database, vertexCollectionName string, edgeCollectionName string) error {
+	createVertices, _ := cmd.Flags().GetBool("create-vertices")
+	graphName, _ := cmd.Flags().GetString("graph")
+	if !createVertices {
+		return nil
+	}
+	firstUid, uids, err := getUidRange(cmd)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	vertices, err := database.CreateOrGetCollection(ctx, db, vertexCollectionName, nil)
+	if err != nil {
+		fmt.Printf("Error: could not create vertex collection `%s`: %v\n", vertexCollectionName, err)
+		return err
+	}
+	start := time.Now()
+	batch := make([]PubmedVertex, 0, 10000)
+	for uid := firstUid; uid < firstUid+uids; uid++ {
+		batch = append(batch, PubmedVertex{Key: "U" + strconv.Itoa(uid), Uid: uid})
+		if len(batch) == cap(batch) || uid == firstUid+uids-1 {
+			ctx2, cancel := context.WithTimeout(driver.WithOverwriteMode(ctx, driver.OverwriteModeIgnore), time.Hour)
+			_, _, err := vertices.CreateDocuments(ctx2, batch)
+			cancel()
+			if err != nil {
+				fmt.Printf("Error: could not create vertices: %v\n", err)
+				return err
+			}
+			batch = batch[0:0]
+		}
+	}
+	fmt.Printf("Created %d vertices in `%s` in %v.\n", uids, vertexCollectionName, time.Since(start))
+
+	if graphName == "" {
+		return nil
+	}
+	edgeDefinition := driver.EdgeDefinition{
+		Collection: edgeCollectionName,
+		From:       []string{vertexCollectionName},
+		To:         []string{vertexCollectionName},
+	}
+	g, err := db.Graph(ctx, graphName)
+	if err == nil {
+		if err := checkGraphDefinition(g, edgeDefinition, ""); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return err
+		}
+		fmt.Printf("Found graph `%s` already.\n", graphName)
+		return nil
+	} else if !driver.IsNotFound(err) {
+		fmt.Printf("Error: could not look for graph `%s`: %v\n", graphName, err)
+		return err
+	}
+	if _, err := db.CreateGraph(ctx, graphName, &driver.CreateGraphOptions{
+		EdgeDefinitions: []driver.EdgeDefinition{edgeDefinition},
+	}); err != nil {
+		fmt.Printf("Error: could not create graph `%s`: %v\n", graphName, err)
+		return err
+	}
+	fmt.Printf("Created graph `%s` over `%s`.\n", graphName, edgeCollectionName)
+	return nil
+}
@@ -2,14 +2,12 @@ package cmd
 
 import (
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/distribution"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"math/rand"
-	"sort"
-	"sync"
 	"time"
 )
 
@@ -19,125 +17,292 @@ var (
 		Short: "Read batchimport",
 		RunE:  readBatchImport,
 	}
-
 )
 
+// batchImportReader describes how read batchimport reads documents.
+type batchImportReader struct {
+	collectionName   string
+	totalNumber      int64
+	keySize          int
+	readFromFollower bool
+	keys             distribution.Config
+	mode             string // "single", "batch", "aql" or "range"
+	keysPerRead      int
+	indexAttribute   string // attribute of the persistent index for "range"
+}
+
 func init() {
 	var parallelism int = 1
 	var startDelay int64 = 5
 	var number int64 = 1000000
-	var totalNumber int64 = 1000000
+	var totalNumber int64 = 0
+	var keySize int = 0
 	var collectionName string = "batchimport"
 	var readFromFollower bool = false
+	var mode string = "single"
+	var keysPerRead int = 10
+	var indexAttribute string = "sha"
 	cmdReadBatchImport.Flags().IntVar(&parallelism, "parallelism", parallelism, "set -parallelism to use multiple go routines")
-	cmdReadBatchImport.Flags().Int64Var(&number, "number", number, "set -number for number of documents to read per go routine")
-	cmdReadBatchImport.Flags().Int64Var(&totalNumber, "total-number", totalNumber, "set -total-number for the total number of documents in the collection")
+	cmdReadBatchImport.Flags().Int64Var(&number, "number", number, "set -number for number of reads per go routine")
+	cmdReadBatchImport.Flags().Int64Var(&totalNumber, "total-number", totalNumber, "set -total-number for the total number of documents in the collection, 0 takes it from the metadata written by write batchimport or the collection count")
+	cmdReadBatchImport.Flags().IntVar(&keySize, "key-size", keySize, "Key size used by write batchimport, 0 takes it from the metadata written by write batchimport")
 	cmdReadBatchImport.Flags().Int64Var(&startDelay, "start-delay", startDelay, "Delay between the start of two go routines.")
 	cmdReadBatchImport.Flags().StringVar(&collectionName, "collection", collectionName, "Name of batch import collection.")
 	cmdReadBatchImport.Flags().BoolVar(&readFromFollower, "read-from-follower", readFromFollower, "Use read-from-followers (aka allow-dirty-reads).")
+	cmdReadBatchImport.Flags().StringVar(&mode, "mode", mode,
+		"How documents are read: 'single' (one key per request), 'batch' (ReadDocuments), 'aql' (DOCUMENT in AQL) or 'range' (range scan on a persistent index)")
+	cmdReadBatchImport.Flags().IntVar(&keysPerRead, "keys-per-read", keysPerRead, "Number of documents read per request in modes batch, aql and range.")
+	cmdReadBatchImport.Flags().StringVar(&indexAttribute, "index-attribute", indexAttribute,
+		"Attribute of the persistent index used in mode range, the index is created if it does not exist.")
+	keyDistributionFlags(cmdReadBatchImport, "uniform")
+	errorBudgetFlags(cmdReadBatchImport)
+	databaseFlag(cmdReadBatchImport)
 }
 
 // readBatchImport reads docs in parallel
 func readBatchImport(cmd *cobra.Command, _ []string) error {
 	parallelism, _ := cmd.Flags().GetInt("parallelism")
 	number, _ := cmd.Flags().GetInt64("number")
-	totalNumber, _ := cmd.Flags().GetInt64("total-number")
 	startDelay, _ := cmd.Flags().GetInt64("start-delay")
-	collectionName, _ := cmd.Flags().GetString("collection")
-	readFromFollower, _ := cmd.Flags().GetBool("read-from-follower")
+	totalNumber, _ := cmd.Flags().GetInt64("total-number")
+	keySize, _ := cmd.Flags().GetInt("key-size")
+
+	reader := batchImportReader{}
+	reader.collectionName, _ = cmd.Flags().GetString("collection")
+	reader.readFromFollower, _ = cmd.Flags().GetBool("read-from-follower")
+	reader.mode, _ = cmd.Flags().GetString("mode")
+	reader.keysPerRead, _ = cmd.Flags().GetInt("keys-per-read")
+	reader.indexAttribute, _ = cmd.Flags().GetString("index-attribute")
+	reader.keys = getKeyDistribution(cmd)
+	if _, err := reader.keys.New(); err != nil {
+		return err
+	}
+	switch reader.mode {
+	case "single":
+		reader.keysPerRead = 1
+	case "batch", "aql", "range":
+		if reader.keysPerRead < 1 {
+			return fmt.Errorf("keys per read must be positive: %d", reader.keysPerRead)
+		}
+	default:
+		return fmt.Errorf("invalid read mode: %s", reader.mode)
+	}
 
-	db, err := _client.Database(context.Background(), "_system")
+	db, err := getDatabase(cmd)
 	if err != nil {
-		return errors.Wrapf(err, "can not get database: %s", "_system")
+		return err
 	}
 
-	if err := readSomeParallel(parallelism, number, startDelay, totalNumber, collectionName, readFromFollower, db); err != nil {
+	if err := reader.discoverKeySpace(db, totalNumber, keySize); err != nil {
+		return err
+	}
+
+	if reader.mode == "range" {
+		if err := reader.ensureIndex(db); err != nil {
+			return err
+		}
+	}
+
+	if err := readSomeParallel(parallelism, number, startDelay, &reader, getErrorBudget(cmd), db); err != nil {
 		return errors.Wrapf(err, "can not do some batchimport reads")
 	}
 
 	return nil
 }
 
-// readSomeBatchesParallel does some batch imports in parallel
-func readSomeParallel(parallelism int, number int64, startDelay int64, totalNumber int64, collectionName string, readFromFollower bool, db driver.Database) error {
-	var mutex sync.Mutex
-	totaltimestart := time.Now()
-	wg := sync.WaitGroup{}
-	haveError := false
-	for i := 1; i <= parallelism; i++ {
-	  time.Sleep(time.Duration(startDelay) * time.Millisecond)
-		i := i // bring into scope
-		wg.Add(1)
-
-		go func(wg *sync.WaitGroup, i int) {
-			defer wg.Done()
-			fmt.Printf("Starting go routine...\n")
-			err := readSome(number, int64(i), totalNumber, collectionName, readFromFollower, db, &mutex)
-			if err != nil {
-				fmt.Printf("readSome error: %v\n", err)
-				haveError = true
-			}
-			mutex.Lock()
-			fmt.Printf("Go routine %d done\n", i)
-			mutex.Unlock()
-		}(&wg, i)
-	}
-
-	wg.Wait()
-	totaltimeend := time.Now()
-	totaltime := totaltimeend.Sub(totaltimestart)
-	docsPerSec := float64(int64(parallelism) * number) / (float64(totaltime) / float64(time.Second))
-	fmt.Printf("\nTotal number of documents read: %d, total time: %v, total docs per second: %f\n", int64(parallelism) * number , totaltimeend.Sub(totaltimestart), docsPerSec)
-	if !haveError {
-		return nil
-	}
-	fmt.Printf("Error in readSome.\n")
-	return fmt.Errorf("Error in readSome.")
+// discoverKeySpace sets the number of documents and the key size as
+// returned by batchImportKeySpace. It fails if the first or the last of
+// these documents does not exist.
+func (b *batchImportReader) discoverKeySpace(db driver.Database, totalNumber int64, keySize int) error {
+	ctx := context.Background()
+	coll, err := db.Collection(ctx, b.collectionName)
+	if err != nil {
+		fmt.Printf("Could not open `%s` collection: %v\n", b.collectionName, err)
+		return err
+	}
+	if totalNumber, keySize, err = batchImportKeySpace(ctx, coll, totalNumber, keySize); err != nil {
+		return err
+	}
+
+	// Check the first and the last document, so that a wrong key space
+	// fails at once instead of with a 404 for many reads:
+	for _, which := range []int64{0, totalNumber - 1} {
+		key := batchImportKey(which, keySize)
+		found, err := coll.DocumentExists(ctx, key)
+		if err != nil {
+			fmt.Printf("Could not look for document %s: %v\n", key, err)
+			return err
+		}
+		if !found {
+			return fmt.Errorf("document %d with key %s not found in `%s`, --total-number %d or --key-size %d "+
+				"do not match the documents written by write batchimport", which, key, b.collectionName, totalNumber, keySize)
+		}
+	}
+	fmt.Printf("Reading from %d documents with key size %d.\n", totalNumber, keySize)
+	b.totalNumber = totalNumber
+	b.keySize = keySize
+	return nil
 }
 
-// readSomeBatches reads `nrDocs` documents (random reads).
-func readSome(nrDocs int64, id int64, totalNumber int64, collectionName string, readFromFollower bool, db driver.Database, mutex *sync.Mutex) error {
-	docs, err := db.Collection(nil, collectionName)
+// ensureIndex creates the persistent index for range scans, which returns
+// at once if the index exists already.
+func (b *batchImportReader) ensureIndex(db driver.Database) error {
+	coll, err := db.Collection(nil, b.collectionName)
 	if err != nil {
-		fmt.Printf("readSome: could not open `%s` collection: %v\n", collectionName, err)
+		fmt.Printf("Could not open `%s` collection: %v\n", b.collectionName, err)
 		return err
 	}
-	var doc Doc
-  times := make([]time.Duration, 0, nrDocs)
-	cyclestart := time.Now()
-	last100start := cyclestart
-	source := rand.New(rand.NewSource(int64(id) + rand.Int63()))
-	for i := int64(1); i <= nrDocs; i++ {
+	start := time.Now()
+	_, created, err := coll.EnsurePersistentIndex(nil, []string{b.indexAttribute}, nil)
+	if err != nil {
+		fmt.Printf("Could not create persistent index on `%s`: %v\n", b.indexAttribute, err)
+		return err
+	}
+	if created {
+		fmt.Printf("Created persistent index on `%s` in %v.\n", b.indexAttribute, time.Since(start))
+	}
+	return nil
+}
+
+// readSomeParallel does some random reads in parallel
+func readSomeParallel(parallelism int, number int64, startDelay int64, reader *batchImportReader, budget runner.ErrorBudget, db driver.Database) error {
+	r := runner.New(runner.Config{
+		Name:        "readSome",
+		Parallelism: parallelism,
+		FirstID:     1,
+		StartDelay:  time.Duration(startDelay) * time.Millisecond,
+		Operation:   "reads",
+		Items:       "docs",
+		Budget:      budget,
+	})
+	_, err := r.Run(func(ctx context.Context, w *runner.Worker) error {
+		return readSome(ctx, w, number, reader, db)
+	})
+	return err
+}
+
+// readSome does `nrReads` random reads, the keys are chosen according to
+// the key distribution of the reader.
+func readSome(ctx context.Context, w *runner.Worker, nrReads int64, reader *batchImportReader, db driver.Database) error {
+	docs, err := db.Collection(ctx, reader.collectionName)
+	if err != nil {
+		w.Printf("readSome: could not open `%s` collection: %v\n", reader.collectionName, err)
+		return err
+	}
+	dist, err := reader.keys.New()
+	if err != nil {
+		return err
+	}
+	last100start := time.Now()
+	source := w.Rand
+	keys := make([]string, reader.keysPerRead)
+	for i := int64(1); i <= nrReads && ctx.Err() == nil; i++ {
 		start := time.Now()
-		which := source.Int63n(totalNumber)
-		x := fmt.Sprintf("%d", which)
-		key := fmt.Sprintf("%x", sha256.Sum256([]byte(x)))
-		var allowDirtyReads bool = readFromFollower
-		ctx, cancel := context.WithTimeout(driver.WithAllowDirtyReads(context.Background(), &allowDirtyReads), time.Hour)
+		for j := range keys {
+			which := dist.Next(source, reader.totalNumber)
+			if reader.mode == "range" {
+				keys[j] = batchImportSha(which)
+			} else {
+				keys[j] = batchImportKey(which, reader.keySize)
+			}
+		}
+		// WithAllowDirtyReads allows dirty reads whatever the value, so it is
+		// only used with -read-from-follower:
+		ctx2 := ctx
+		if reader.readFromFollower {
+			var wasDirty bool
+			ctx2 = driver.WithAllowDirtyReads(ctx2, &wasDirty)
+		}
+		ctx2, cancel := context.WithTimeout(ctx2, time.Hour)
 
-		_, err := docs.ReadDocument(ctx, key, &doc)
+		var nr int64
+		switch reader.mode {
+		case "single":
+			var doc Doc
+			_, err = docs.ReadDocument(ctx2, keys[0], &doc)
+			nr = 1
+		case "batch":
+			nr, err = readDocumentsBatch(ctx2, docs, keys)
+		case "aql":
+			nr, err = readDocumentsAQL(ctx2, db, reader.collectionName, keys)
+		case "range":
+			nr, err = readRange(ctx2, db, reader.collectionName, reader.indexAttribute, keys[0], len(keys))
+		}
 		cancel()
 		if err != nil {
-			fmt.Printf("readSome: could not read document: %v\n", err)
-			return err
+			w.Printf("readSome: could not read documents: %v\n", err)
+			if err := w.Fail(err); err != nil {
+				return err
+			}
+			continue
 		}
-    times = append(times, time.Now().Sub(start))
-		if i % 100000 == 0 {
+		w.Record(start, nr)
+		if i%100000 == 0 {
 			dur := float64(time.Now().Sub(last100start)) / float64(time.Second)
-			mutex.Lock()
-			fmt.Printf("%s Have read %d docs for id %d, last 100000 took %f seconds.\n", time.Now(), int(i), id, dur)
-			mutex.Unlock()
+			w.Printf("%s Have done %d reads for id %d, last 100000 took %f seconds.\n", time.Now(), int(i), w.ID, dur)
+			last100start = time.Now()
 		}
 	}
-	sort.Sort(DurationSlice(times))
-	var sum int64 = 0
-	for _, t := range times {
-		sum = sum + int64(t)
-	}
-	totaltime := time.Now().Sub(cyclestart)
-	docspersec := float64(nrDocs) / (float64(totaltime) / float64(time.Second))
-	mutex.Lock()
-	fmt.Printf("Times for reading %d docs: %s (median), %s (90%%ile), %s (99%%ilie), %s (average), docs per second in this go routine: %f\n", nrDocs, times[int(float64(0.5) * float64(nrDocs))], times[int(float64(0.9) * float64(nrDocs))], times[int(float64(0.99) * float64(nrDocs))], time.Duration(sum / nrDocs), docspersec)
-	mutex.Unlock()
 	return nil
 }
+
+// readDocumentsBatch reads the documents with the given keys with one
+// ReadDocuments call.
+func readDocumentsBatch(ctx context.Context, docs driver.Collection, keys []string) (int64, error) {
+	results := make([]Doc, len(keys))
+	_, errs, err := docs.ReadDocuments(ctx, keys, results)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range errs {
+		if e != nil {
+			return 0, e
+		}
+	}
+	return int64(len(keys)), nil
+}
+
+// readDocumentsAQL reads the documents with the given keys with DOCUMENT
+// in an AQL query, missing documents are wrong results.
+func readDocumentsAQL(ctx context.Context, db driver.Database, collectionName string, keys []string) (int64, error) {
+	cursor, err := db.Query(ctx, "FOR k IN @keys RETURN DOCUMENT(@@col, k)",
+		map[string]interface{}{"@col": collectionName, "keys": keys})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+	var count int64
+	for cursor.HasMore() {
+		var doc *Doc
+		if _, err := cursor.ReadDocument(ctx, &doc); err != nil {
+			return count, err
+		}
+		if doc != nil {
+			count++
+		}
+	}
+	if count != int64(len(keys)) {
+		return count, errors.Wrapf(runner.ErrWrongResult, "found %d of %d documents", count, len(keys))
+	}
+	return count, nil
+}
+
+// readRange reads up to `limit` documents in the order of `attribute`
+// starting at `low`, using its persistent index.
+func readRange(ctx context.Context, db driver.Database, collectionName string, attribute string, low string, limit int) (int64, error) {
+	cursor, err := db.Query(ctx, "FOR d IN @@col FILTER d.@attr >= @low SORT d.@attr LIMIT @limit RETURN d",
+		map[string]interface{}{"@col": collectionName, "attr": attribute, "low": low, "limit": limit})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+	var count int64
+	for cursor.HasMore() {
+		var doc Doc
+		if _, err := cursor.ReadDocument(ctx, &doc); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
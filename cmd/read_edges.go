@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"strings"
+	"time"
+)
+
+var (
+	cmdReadEdges = &cobra.Command{
+		Use:   "edges",
+		Short: "Read edges written by write edges using their indexes",
+		RunE:  readEdges,
+	}
+)
+
+// edgeReadQueries are the AQL queries of read edges by mode. The score
+// range scan and the uid lookups use the persistent indexes of create
+// edgecol, the traversal uses the edge index.
+var edgeReadQueries = map[string]string{
+	"score":     `FOR e IN @@col FILTER e.score >= @low AND e.score < @high SORT e.score LIMIT @limit RETURN e`,
+	"fromUid":   `FOR e IN @@col FILTER e.fromUid == @uid LIMIT @limit RETURN e`,
+	"toUid":     `FOR e IN @@col FILTER e.toUid == @uid LIMIT @limit RETURN e`,
+	"traversal": `FOR v, e IN 1..@depth OUTBOUND @start @@col LIMIT @limit RETURN e`,
+}
+
+// edgeReader describes how read edges reads edges.
+type edgeReader struct {
+	collectionName       string
+	vertexCollectionName string
+	modes                []string
+	selectivity          float64 // fraction of the score range of a range scan
+	maxScore             int
+	firstUid             int
+	uids                 int
+	depth                int
+	limit                int
+}
+
+func init() {
+	var parallelism int = 1
+	var startDelay int64 = 5
+	var number int64 = 100000
+	var collectionName string = "edges"
+	var vertexCollectionName string = "pubmed"
+	var modes string = "score,fromUid,toUid,traversal"
+	var selectivity float64 = 0.0001
+	var maxScore int = 10000000
+	var depth int = 2
+	var limit int = 1000
+	cmdRead.AddCommand(cmdReadEdges)
+	cmdReadEdges.Flags().IntVar(&parallelism, "parallelism", parallelism, "set -parallelism to use multiple go routines")
+	cmdReadEdges.Flags().Int64Var(&number, "number", number, "set -number for number of reads per go routine")
+	cmdReadEdges.Flags().Int64Var(&startDelay, "start-delay", startDelay, "Delay between the start of two go routines.")
+	cmdReadEdges.Flags().StringVar(&collectionName, "collection", collectionName, "Name of edge collection.")
+	cmdReadEdges.Flags().StringVar(&vertexCollectionName, "vertex-collection", vertexCollectionName, "Name of vertex collection the edges point to.")
+	cmdReadEdges.Flags().StringVar(&modes, "mode", modes,
+		"Comma separated list of reads: 'score' (range scan on score), 'fromUid' and 'toUid' (lookups) and 'traversal' (from a random vertex)")
+	cmdReadEdges.Flags().Float64Var(&selectivity, "selectivity", selectivity, "Fraction of all scores a range scan on score covers")
+	cmdReadEdges.Flags().IntVar(&maxScore, "max-score", maxScore, "Scores of write edges are below this number")
+	cmdReadEdges.Flags().IntVar(&depth, "depth", depth, "Maximal depth of traversals")
+	cmdReadEdges.Flags().IntVar(&limit, "limit", limit, "Maximal number of edges returned by a read")
+	uidRangeFlags(cmdReadEdges)
+	errorBudgetFlags(cmdReadEdges)
+	databaseFlag(cmdReadEdges)
+}
+
+// readEdges reads edges in parallel
+func readEdges(cmd *cobra.Command, _ []string) error {
+	parallelism, _ := cmd.Flags().GetInt("parallelism")
+	number, _ := cmd.Flags().GetInt64("number")
+	startDelay, _ := cmd.Flags().GetInt64("start-delay")
+	modes, _ := cmd.Flags().GetString("mode")
+
+	reader := edgeReader{modes: strings.Split(modes, ",")}
+	reader.collectionName, _ = cmd.Flags().GetString("collection")
+	reader.vertexCollectionName, _ = cmd.Flags().GetString("vertex-collection")
+	reader.selectivity, _ = cmd.Flags().GetFloat64("selectivity")
+	reader.maxScore, _ = cmd.Flags().GetInt("max-score")
+	reader.depth, _ = cmd.Flags().GetInt("depth")
+	reader.limit, _ = cmd.Flags().GetInt("limit")
+	var err error
+	if reader.firstUid, reader.uids, err = getUidRange(cmd); err != nil {
+		return err
+	}
+	for _, mode := range reader.modes {
+		if _, ok := edgeReadQueries[mode]; !ok {
+			return fmt.Errorf("invalid read mode: %s", mode)
+		}
+	}
+	if reader.selectivity <= 0 || reader.selectivity > 1 {
+		return fmt.Errorf("selectivity must be between 0 and 1: %f", reader.selectivity)
+	}
+	if reader.maxScore < 1 || reader.depth < 1 || reader.limit < 1 {
+		return fmt.Errorf("max score, depth and limit must be positive")
+	}
+
+	db, err := getDatabase(cmd)
+	if err != nil {
+		return err
+	}
+
+	r := runner.New(runner.Config{
+		Name:        "readEdges",
+		Parallelism: parallelism,
+		FirstID:     1,
+		StartDelay:  time.Duration(startDelay) * time.Millisecond,
+		Operation:   "reads",
+		Items:       "edges",
+		Budget:      getErrorBudget(cmd),
+	})
+	_, err = r.Run(func(ctx context.Context, w *runner.Worker) error {
+		for i := int64(1); i <= number && ctx.Err() == nil; i++ {
+			if err := reader.readOnce(ctx, w, db); err != nil {
+				if err := w.Fail(err); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "can not do some edge reads")
+	}
+	return nil
+}
+
+// readOnce does one read of a random mode and checks that the edges match
+// the filter of the query.
+func (e *edgeReader) readOnce(ctx context.Context, w *runner.Worker, db driver.Database) error {
+	mode := e.modes[w.Rand.Intn(len(e.modes))]
+	bindVars := map[string]interface{}{"@col": e.collectionName, "limit": e.limit}
+	var low, high, uid int
+	switch mode {
+	case "score":
+		width := int(e.selectivity * float64(e.maxScore))
+		if width < 1 {
+			width = 1
+		}
+		low = w.Rand.Intn(e.maxScore)
+		high = low + width
+		bindVars["low"] = low
+		bindVars["high"] = high
+	case "fromUid", "toUid":
+		uid = e.firstUid + w.Rand.Intn(e.uids)
+		bindVars["uid"] = uid
+	case "traversal":
+		bindVars["start"] = uidVertex(e.vertexCollectionName, e.firstUid+w.Rand.Intn(e.uids))
+		bindVars["depth"] = e.depth
+	}
+
+	start := time.Now()
+	ctx2, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+	cursor, err := db.Query(ctx2, edgeReadQueries[mode], bindVars)
+	if err != nil {
+		w.Printf("readEdges: could not run %s query: %v\n", mode, err)
+		return err
+	}
+	defer cursor.Close()
+	var count int64
+	previous := low
+	for cursor.HasMore() {
+		var edge Edge
+		if _, err := cursor.ReadDocument(ctx2, &edge); err != nil {
+			w.Printf("readEdges: could not read result of %s query: %v\n", mode, err)
+			return err
+		}
+		count++
+		var wrong bool
+		switch mode {
+		case "score":
+			wrong = edge.Score < previous || edge.Score >= high
+			previous = edge.Score
+		case "fromUid":
+			wrong = edge.FromUid != uid
+		case "toUid":
+			wrong = edge.ToUid != uid
+		}
+		if wrong {
+			w.Printf("readEdges: %s query returned a wrong edge: %+v\n", mode, edge)
+			return errors.Wrapf(runner.ErrWrongResult, "%s query returned a wrong edge", mode)
+		}
+	}
+	w.RecordAs(mode, start, count)
+	return nil
+}
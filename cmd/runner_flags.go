@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/neunhoef/collectionmaker/pkg/runner"
+	"github.com/spf13/cobra"
+)
+
+// errorBudgetFlags adds the flags which control how many failed operations
+// a parallel run tolerates.
+func errorBudgetFlags(command *cobra.Command) {
+	var maxErrors int64
+	var maxErrorRate float64
+
+	command.Flags().Int64Var(&maxErrors, "max-errors", 0,
+		"Number of failed operations to tolerate before the run stops, 0 stops at the first error unless --max-error-rate is set")
+	command.Flags().Float64Var(&maxErrorRate, "max-error-rate", 0,
+		"Fraction of failed operations (e.g. 0.01) to tolerate before the run stops, 0 means no limit by rate")
+}
+
+// getErrorBudget reads the flags added by errorBudgetFlags.
+func getErrorBudget(cmd *cobra.Command) runner.ErrorBudget {
+	maxErrors, _ := cmd.Flags().GetInt64("max-errors")
+	maxErrorRate, _ := cmd.Flags().GetFloat64("max-error-rate")
+	return runner.ErrorBudget{
+		MaxErrors:    maxErrors,
+		MaxErrorRate: maxErrorRate,
+	}
+}
@@ -0,0 +1,360 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	cmdTestAQL = &cobra.Command{
+		Use:   "aql",
+		Short: "Run a weighted mixture of AQL queries from a query file",
+		RunE:  testAQL,
+	}
+)
+
+// aqlQuery is one entry of the query file of test aql.
+type aqlQuery struct {
+	Name          string                       `yaml:"name"`
+	Query         string                       `yaml:"query"`
+	Weight        *int                         `yaml:"weight"` // default 1, 0 disables the query
+	BindVars      map[string]*bindVarGenerator `yaml:"bindVars"`
+	ExpectedCount *int                         `yaml:"expectedCount"`
+	BatchSize     int                          `yaml:"batchSize"`
+	Stream        bool                         `yaml:"stream"`
+	Timeout       time.Duration                `yaml:"timeout"`
+}
+
+// bindVarGenerator produces the value of a bind parameter for every run of
+// a query.
+type bindVarGenerator struct {
+	// Type is one of "value", "int", "key", "list" or "tenant".
+	Type string `yaml:"type"`
+	// Value is the constant value for type "value".
+	Value interface{} `yaml:"value"`
+	// Min and Max give the range for "int" and "tenant" and the range of
+	// document numbers or tenants for "key", both inclusive.
+	Min int64 `yaml:"min"`
+	Max int64 `yaml:"max"`
+	// Items are the values for type "list".
+	Items []interface{} `yaml:"items"`
+	// Strategy chooses the keys for type "key": "batchimport" (keys
+	// written by write batchimport) or "path" (vertices written by create
+	// graph).
+	Strategy string `yaml:"strategy"`
+	// KeySize is the key size used by write batchimport, default 64.
+	KeySize int `yaml:"keySize"`
+	// Role and Paths give the vertex role (K, L or M) and the number of
+	// paths per tenant for strategy "path".
+	Role  string `yaml:"role"`
+	Paths int64  `yaml:"paths"`
+	// Prefix is put in front of generated keys, e.g. "instances/".
+	Prefix string `yaml:"prefix"`
+}
+
+func init() {
+	var queriesFile string
+	var runTimeSeconds, parallelism int
+
+	cmdTest.AddCommand(cmdTestAQL)
+	cmdTestAQL.Flags().StringVar(&queriesFile, "queries", "", "YAML file with the queries to run")
+	cmdTestAQL.Flags().IntVar(&runTimeSeconds, "runTime", 30, "Run time in seconds")
+	cmdTestAQL.Flags().IntVar(&parallelism, "parallelism", 4, "Parallelism")
+	databaseFlag(cmdTestAQL)
+	errorBudgetFlags(cmdTestAQL)
+}
+
+func testAQL(cmd *cobra.Command, _ []string) error {
+	queriesFile, _ := cmd.Flags().GetString("queries")
+	runTimeSeconds, _ := cmd.Flags().GetInt("runTime")
+	parallelism, _ := cmd.Flags().GetInt("parallelism")
+
+	if queriesFile == "" {
+		return fmt.Errorf("no query file given, use --queries")
+	}
+	queries, err := readAQLQueries(queriesFile)
+	if err != nil {
+		return err
+	}
+
+	db, err := getDatabase(cmd)
+	if err != nil {
+		return err
+	}
+
+	r := runner.New(runner.Config{
+		Name:        "runAQLTest",
+		Parallelism: parallelism,
+		FirstID:     1,
+		Operation:   "queries",
+		ReportEvery: 1000,
+		Budget:      getErrorBudget(cmd),
+	})
+	totalWeight := 0
+	for _, q := range queries {
+		totalWeight += *q.Weight
+	}
+	if totalWeight == 0 {
+		return fmt.Errorf("all queries in %s have weight 0", queriesFile)
+	}
+	startTime := time.Now()
+	limit := time.Duration(runTimeSeconds) * time.Second
+	_, err = r.Run(func(ctx context.Context, w *runner.Worker) error {
+		for time.Since(startTime) < limit && ctx.Err() == nil {
+			if err := runAQLQuery(ctx, w, db, pickAQLQuery(w, queries, totalWeight)); err != nil {
+				if err := w.Fail(err); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// readAQLQueries reads and checks the YAML list of queries.
+func readAQLQueries(filename string) ([]aqlQuery, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not read query file: %s", filename)
+	}
+	var queries []aqlQuery
+	if err := yaml.UnmarshalStrict(content, &queries); err != nil {
+		return nil, errors.Wrapf(err, "can not parse query file: %s", filename)
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("query file %s contains no queries", filename)
+	}
+	for i := range queries {
+		if err := queries[i].validate(i); err != nil {
+			return nil, errors.Wrapf(err, "invalid query in %s", filename)
+		}
+	}
+	return queries, nil
+}
+
+// validate checks the query and fills in defaults.
+func (q *aqlQuery) validate(index int) error {
+	if q.Name == "" {
+		q.Name = "query" + strconv.Itoa(index+1)
+	}
+	if q.Query == "" {
+		return fmt.Errorf("%s has no query string", q.Name)
+	}
+	if q.Weight == nil {
+		one := 1
+		q.Weight = &one
+	}
+	if *q.Weight < 0 {
+		return fmt.Errorf("weight of %s must not be negative: %d", q.Name, *q.Weight)
+	}
+	if q.BatchSize < 0 {
+		return fmt.Errorf("batch size of %s must not be negative: %d", q.Name, q.BatchSize)
+	}
+	if q.Timeout == 0 {
+		q.Timeout = time.Hour
+	}
+	for name, g := range q.BindVars {
+		if g == nil {
+			return fmt.Errorf("bind parameter %s of %s has no generator", name, q.Name)
+		}
+		if err := g.validate(); err != nil {
+			return errors.Wrapf(err, "bind parameter %s of %s", name, q.Name)
+		}
+	}
+	return nil
+}
+
+// validate checks the generator and fills in defaults.
+func (g *bindVarGenerator) validate() error {
+	if g.Type == "" {
+		g.Type = "value"
+	}
+	switch g.Type {
+	case "value":
+		g.Value = yamlToJSON(g.Value)
+	case "int":
+		if g.Max < g.Min {
+			return fmt.Errorf("invalid range: %d..%d", g.Min, g.Max)
+		}
+	case "tenant":
+		return g.validateTenants()
+	case "list":
+		if len(g.Items) == 0 {
+			return fmt.Errorf("list has no items")
+		}
+		for i := range g.Items {
+			g.Items[i] = yamlToJSON(g.Items[i])
+		}
+	case "key":
+		if g.Max < g.Min {
+			return fmt.Errorf("invalid range: %d..%d", g.Min, g.Max)
+		}
+		switch g.Strategy {
+		case "batchimport":
+			if g.Min == 0 && g.Max == 0 {
+				return fmt.Errorf("key strategy batchimport needs the range of documents min..max")
+			}
+			if g.KeySize == 0 {
+				g.KeySize = 64
+			}
+			if g.KeySize < 1 || g.KeySize > 64 {
+				return fmt.Errorf("invalid key size: %d", g.KeySize)
+			}
+		case "path":
+			if g.Role == "" {
+				g.Role = "K"
+			}
+			if g.Role != "K" && g.Role != "L" && g.Role != "M" {
+				return fmt.Errorf("invalid role: %s", g.Role)
+			}
+			if g.Paths <= 0 {
+				g.Paths = defaultNrPathsPerTenant
+			}
+			return g.validateTenants()
+		default:
+			return fmt.Errorf("invalid key strategy: %s", g.Strategy)
+		}
+	default:
+		return fmt.Errorf("invalid generator type: %s", g.Type)
+	}
+	return nil
+}
+
+// validateTenants checks the range of tenants, which is the one of create
+// graph if it is not given.
+func (g *bindVarGenerator) validateTenants() error {
+	if g.Min == 0 && g.Max == 0 {
+		g.Min, g.Max = defaultFirstTenant, defaultLastTenant
+	}
+	if g.Min < 1 || g.Max < g.Min {
+		return fmt.Errorf("invalid range of tenants: %d..%d", g.Min, g.Max)
+	}
+	return nil
+}
+
+// yamlToJSON converts the maps decoded by yaml, which can have keys of any
+// type, into maps which can be sent as JSON.
+func yamlToJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[fmt.Sprintf("%v", key)] = yamlToJSON(elem)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = yamlToJSON(v[i])
+		}
+		return v
+	}
+	return value
+}
+
+// generate returns a random value of the bind parameter. `tenant` is the
+// tenant number used by all generators of one query run, so that tenant
+// ids and path keys fit together.
+func (g *bindVarGenerator) generate(w *runner.Worker, tenant int64) interface{} {
+	switch g.Type {
+	case "int":
+		return g.Min + w.Rand.Int63n(g.Max-g.Min+1)
+	case "list":
+		return g.Items[w.Rand.Intn(len(g.Items))]
+	case "tenant":
+		return "ten" + strconv.FormatInt(tenant, 10)
+	case "key":
+		if g.Strategy == "path" {
+			return g.Prefix + "ten" + strconv.FormatInt(tenant, 10) + ":" + g.Role +
+				strconv.FormatInt(w.Rand.Int63n(g.Paths)+1, 10)
+		}
+		return g.Prefix + batchImportKey(g.Min+w.Rand.Int63n(g.Max-g.Min+1), g.KeySize)
+	}
+	return g.Value
+}
+
+// bindVars returns random bind parameters for one run of the query.
+func (q *aqlQuery) bindVars(w *runner.Worker) map[string]interface{} {
+	if len(q.BindVars) == 0 {
+		return nil
+	}
+	// Draw the tenant from the first generator which has one, in the order
+	// of the parameter names to be independent of the map order:
+	names := make([]string, 0, len(q.BindVars))
+	for name := range q.BindVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tenant := int64(0)
+	for _, name := range names {
+		g := q.BindVars[name]
+		if g.Type == "tenant" || (g.Type == "key" && g.Strategy == "path") {
+			tenant = g.Min + w.Rand.Int63n(g.Max-g.Min+1)
+			break
+		}
+	}
+	bindVars := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		bindVars[name] = q.BindVars[name].generate(w, tenant)
+	}
+	return bindVars
+}
+
+// pickAQLQuery chooses one of the queries according to their weights.
+func pickAQLQuery(w *runner.Worker, queries []aqlQuery, totalWeight int) *aqlQuery {
+	x := w.Rand.Intn(totalWeight)
+	for i := range queries {
+		if x < *queries[i].Weight {
+			return &queries[i]
+		}
+		x -= *queries[i].Weight
+	}
+	return &queries[len(queries)-1]
+}
+
+// runAQLQuery runs the query once with random bind parameters, reads all
+// results and checks their number.
+func runAQLQuery(ctx context.Context, w *runner.Worker, db driver.Database, q *aqlQuery) error {
+	start := time.Now()
+	bindVars := q.bindVars(w)
+	ctx2, cancel := context.WithTimeout(ctx, q.Timeout)
+	defer cancel()
+	if q.BatchSize > 0 {
+		ctx2 = driver.WithQueryBatchSize(ctx2, q.BatchSize)
+	}
+	if q.Stream {
+		ctx2 = driver.WithQueryStream(ctx2, true)
+	}
+	cursor, err := db.Query(ctx2, q.Query, bindVars)
+	if err != nil {
+		w.Printf("Error running query %s: %v\n", q.Name, err)
+		return err
+	}
+	defer cursor.Close()
+	count := 0
+	for cursor.HasMore() {
+		var result json.RawMessage
+		if _, err := cursor.ReadDocument(ctx2, &result); err != nil {
+			w.Printf("Error reading result of query %s from cursor: %v\n", q.Name, err)
+			return err
+		}
+		count++
+	}
+	if q.ExpectedCount != nil && count != *q.ExpectedCount {
+		w.Printf("Got wrong count for query %s: %d, expected: %d, bind parameters: %v\n",
+			q.Name, count, *q.ExpectedCount, bindVars)
+		return errors.Wrapf(runner.ErrWrongResult, "got %d instead of %d results from %s", count, *q.ExpectedCount, q.Name)
+	}
+	w.RecordAs(q.Name, start, 1)
+	return nil
+}
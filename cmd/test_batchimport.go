@@ -0,0 +1,304 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	cmdTestBatchImport = &cobra.Command{
+		Use:   "batchimport",
+		Short: "Verify the documents written by write batchimport",
+		RunE:  testBatchImport,
+	}
+)
+
+// maxReportedProblems is the number of missing or corrupted documents
+// which are printed, the others are only counted.
+const maxReportedProblems = 20
+
+// batchImportCheck is the state of test batchimport shared by all go
+// routines, the counters are accessed atomically.
+type batchImportCheck struct {
+	collectionName string
+	totalNumber    int64
+	keySize        int
+	batchSize      int64
+	sample         int64
+	checked        int64
+	missing        int64
+	corrupted      int64
+	collided       int64
+	reported       int64
+	mutex          sync.Mutex
+	suspects       map[string][]suspectDoc // documents with a wrong sha, by key
+}
+
+// suspectDoc is a document with a wrong sha, which may have been replaced
+// by another document with the same truncated key.
+type suspectDoc struct {
+	which int64
+	sha   string
+}
+
+// maxCollisionScan is the largest number of documents whose keys are
+// computed to tell key collisions from corrupted documents.
+const maxCollisionScan = 100000000
+
+// maxSuspects is the largest number of documents with a wrong sha which
+// are kept to look for collisions, further ones count as corrupted.
+const maxSuspects = 100000
+
+// checkedDoc is a document as read back, with pointers to tell missing
+// attributes from empty ones.
+type checkedDoc struct {
+	Key     string  `json:"_key"`
+	Sha     *string `json:"sha"`
+	Payload *string `json:"payload"`
+}
+
+func init() {
+	var parallelism int = 4
+	var totalNumber int64 = 0
+	var keySize int = 0
+	var batchSize int64 = 1000
+	var sample int64 = 0
+	var collectionName string = "batchimport"
+	cmdTest.AddCommand(cmdTestBatchImport)
+	cmdTestBatchImport.Flags().IntVar(&parallelism, "parallelism", parallelism, "set -parallelism to use multiple go routines")
+	cmdTestBatchImport.Flags().Int64Var(&totalNumber, "total-number", totalNumber, "Number of documents written by write batchimport, 0 takes it from its metadata or the collection count")
+	cmdTestBatchImport.Flags().IntVar(&keySize, "key-size", keySize, "Key size used by write batchimport, 0 takes it from its metadata")
+	cmdTestBatchImport.Flags().Int64Var(&batchSize, "batch-size", batchSize, "Number of documents read per request.")
+	cmdTestBatchImport.Flags().Int64Var(&sample, "sample", sample, "Number of random documents to check, 0 checks all documents.")
+	cmdTestBatchImport.Flags().StringVar(&collectionName, "collection", collectionName, "Name of batch import collection.")
+	errorBudgetFlags(cmdTestBatchImport)
+	databaseFlag(cmdTestBatchImport)
+}
+
+// testBatchImport checks that all (or a sample of) the documents written
+// by write batchimport exist with the expected content and that there are
+// no duplicates.
+func testBatchImport(cmd *cobra.Command, _ []string) error {
+	parallelism, _ := cmd.Flags().GetInt("parallelism")
+	totalNumber, _ := cmd.Flags().GetInt64("total-number")
+	keySize, _ := cmd.Flags().GetInt("key-size")
+
+	c := batchImportCheck{suspects: make(map[string][]suspectDoc)}
+	c.collectionName, _ = cmd.Flags().GetString("collection")
+	c.batchSize, _ = cmd.Flags().GetInt64("batch-size")
+	c.sample, _ = cmd.Flags().GetInt64("sample")
+	if c.batchSize < 1 {
+		return fmt.Errorf("batch size must be positive: %d", c.batchSize)
+	}
+
+	db, err := getDatabase(cmd)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	coll, err := db.Collection(ctx, c.collectionName)
+	if err != nil {
+		fmt.Printf("Could not open `%s` collection: %v\n", c.collectionName, err)
+		return err
+	}
+	if c.totalNumber, c.keySize, err = batchImportKeySpace(ctx, coll, totalNumber, keySize); err != nil {
+		return err
+	}
+
+	toCheck := c.totalNumber
+	if c.sample > 0 {
+		toCheck = c.sample
+	}
+	fmt.Printf("Checking %d of %d documents with key size %d.\n", toCheck, c.totalNumber, c.keySize)
+	r := runner.New(runner.Config{
+		Name:        "testBatchImport",
+		Parallelism: parallelism,
+		Operation:   "batches",
+		Items:       "docs",
+		Budget:      getErrorBudget(cmd),
+	})
+	nrBatches := int((toCheck + c.batchSize - 1) / c.batchSize)
+	_, err = r.RunJobs(0, nrBatches-1, func(ctx context.Context, w *runner.Worker, batch int) error {
+		return c.checkBatch(ctx, w, coll, int64(batch))
+	})
+	if err != nil {
+		return errors.Wrapf(err, "can not check batchimport documents")
+	}
+	c.findCollisions()
+
+	duplicates, err := c.findDuplicates(ctx, db)
+	if err != nil {
+		return errors.Wrapf(err, "can not look for duplicate documents")
+	}
+	count, err := countBatchImportDocuments(ctx, coll)
+	if err != nil {
+		return errors.Wrapf(err, "can not count documents")
+	}
+
+	fmt.Printf("Checked %d documents: %d missing, %d corrupted, %d duplicate sha values.\n",
+		c.checked, c.missing, c.corrupted, duplicates)
+	if c.keySize < 64 {
+		fmt.Printf("%d documents were replaced by another one with the same key of size %d, %.1f are expected in total.\n",
+			c.collided, c.keySize, expectedKeyCollisions(c.totalNumber, c.keySize))
+	}
+	fmt.Printf("Collection has %d documents, %d are expected.\n", count, c.totalNumber)
+	if c.missing > 0 || c.corrupted > 0 || duplicates > 0 {
+		return fmt.Errorf("verification of `%s` failed", c.collectionName)
+	}
+	return nil
+}
+
+// indexes returns the document numbers checked in batch `batch`: a range
+// of consecutive documents or random ones if only a sample is checked.
+func (c *batchImportCheck) indexes(w *runner.Worker, batch int64) []int64 {
+	first := batch * c.batchSize
+	last := first + c.batchSize
+	limit := c.totalNumber
+	if c.sample > 0 {
+		limit = c.sample
+	}
+	if last > limit {
+		last = limit
+	}
+	indexes := make([]int64, 0, last-first)
+	for i := first; i < last; i++ {
+		if c.sample > 0 {
+			indexes = append(indexes, w.Rand.Int63n(c.totalNumber))
+		} else {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// report prints a problem unless enough have been printed already.
+func (c *batchImportCheck) report(w *runner.Worker, format string, args ...interface{}) {
+	if atomic.AddInt64(&c.reported, 1) <= maxReportedProblems {
+		w.Printf(format, args...)
+	}
+}
+
+// checkBatch reads one batch of documents and compares them with the
+// documents write batchimport has written.
+func (c *batchImportCheck) checkBatch(ctx context.Context, w *runner.Worker, coll driver.Collection, batch int64) error {
+	start := time.Now()
+	indexes := c.indexes(w, batch)
+	keys := make([]string, len(indexes))
+	for i, which := range indexes {
+		keys[i] = batchImportKey(which, c.keySize)
+	}
+	docs := make([]checkedDoc, len(keys))
+	ctx2, cancel := context.WithTimeout(ctx, time.Hour)
+	_, errs, err := coll.ReadDocuments(ctx2, keys, docs)
+	cancel()
+	if err != nil {
+		w.Printf("testBatchImport: could not read batch %d: %v\n", batch, err)
+		return w.Fail(err)
+	}
+	for i, which := range indexes {
+		if errs[i] != nil {
+			if !driver.IsNotFound(errs[i]) {
+				w.Printf("testBatchImport: could not read document %s: %v\n", keys[i], errs[i])
+				return w.Fail(errs[i])
+			}
+			atomic.AddInt64(&c.missing, 1)
+			c.report(w, "Missing document %d with key %s\n", which, keys[i])
+			continue
+		}
+		if docs[i].Sha == nil || *docs[i].Sha != batchImportSha(which) {
+			// With truncated keys another document can have replaced this
+			// one, which findCollisions decides at the end:
+			if docs[i].Sha == nil || c.keySize >= 64 || !c.addSuspect(keys[i], which, *docs[i].Sha) {
+				atomic.AddInt64(&c.corrupted, 1)
+				c.report(w, "Document %d with key %s has a wrong sha\n", which, keys[i])
+			}
+		} else if docs[i].Payload == nil {
+			atomic.AddInt64(&c.corrupted, 1)
+			c.report(w, "Document %d with key %s has no payload\n", which, keys[i])
+		}
+	}
+	atomic.AddInt64(&c.checked, int64(len(indexes)))
+	w.Record(start, int64(len(indexes)))
+	return nil
+}
+
+// findDuplicates returns the number of sha values which occur in more than
+// one document, i.e. documents which exist under a wrong key as well.
+func (c *batchImportCheck) findDuplicates(ctx context.Context, db driver.Database) (int64, error) {
+	cursor, err := db.Query(ctx, `FOR d IN @@col FILTER d.sha != null
+	                              COLLECT sha = d.sha WITH COUNT INTO n FILTER n > 1 RETURN {sha, n}`,
+		map[string]interface{}{"@col": c.collectionName})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+	var count int64
+	for cursor.HasMore() {
+		var dup struct {
+			Sha string `json:"sha"`
+			N   int64  `json:"n"`
+		}
+		if _, err := cursor.ReadDocument(ctx, &dup); err != nil {
+			return count, err
+		}
+		count++
+		if count <= maxReportedProblems {
+			fmt.Printf("sha %s occurs in %d documents\n", dup.Sha, dup.N)
+		}
+	}
+	return count, nil
+}
+
+// addSuspect keeps a document with a wrong sha to look for a collision
+// later and returns false if there are too many already.
+func (c *batchImportCheck) addSuspect(key string, which int64, sha string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.suspects) >= maxSuspects {
+		return false
+	}
+	c.suspects[key] = append(c.suspects[key], suspectDoc{which, sha})
+	return true
+}
+
+// findCollisions decides for the documents with a wrong sha whether they
+// have been replaced by another document with the same truncated key,
+// see write batchimport, or are corrupted. This computes the keys of all
+// documents once, so it is refused for more than maxCollisionScan.
+func (c *batchImportCheck) findCollisions() {
+	if len(c.suspects) == 0 {
+		return
+	}
+	collided := make(map[suspectDoc]bool)
+	if c.totalNumber <= maxCollisionScan {
+		for j := int64(0); j < c.totalNumber; j++ {
+			for _, s := range c.suspects[batchImportKey(j, c.keySize)] {
+				if s.which != j && batchImportSha(j) == s.sha {
+					collided[s] = true
+				}
+			}
+		}
+	} else {
+		fmt.Printf("Not looking for key collisions among more than %d documents, wrong sha values count as corrupted.\n",
+			maxCollisionScan)
+	}
+	for key, docs := range c.suspects {
+		for _, s := range docs {
+			if collided[s] {
+				c.collided++
+				continue
+			}
+			c.corrupted++
+			if c.reported++; c.reported <= maxReportedProblems {
+				fmt.Printf("Document %d with key %s has a wrong sha\n", s.which, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"strings"
+	"time"
+)
+
+var (
+	cmdTestGeo = &cobra.Command{
+		Use:   "geo",
+		Short: "Run geo queries on the batchimport collection",
+		RunE:  testGeo,
+	}
+)
+
+// geoQueries are the AQL queries of test geo by kind. @shape is a random
+// square, @point its south west corner.
+var geoQueries = map[string]string{
+	"intersects": `FOR d IN @@col FILTER GEO_INTERSECTS(@shape, d.geo) LIMIT @limit RETURN d._key`,
+	"contains":   `FOR d IN @@col FILTER GEO_CONTAINS(@shape, d.geo) LIMIT @limit RETURN d._key`,
+	"near": `FOR d IN @@col LET dist = GEO_DISTANCE(@point, d.geo) FILTER dist <= @radius
+	         SORT dist LIMIT @limit RETURN d._key`,
+}
+
+func init() {
+	var parallelism int = 4
+	var runTimeSeconds int = 30
+	var collectionName string = "batchimport"
+	var queries string = "intersects,contains,near"
+	var querySize float64 = 5
+	var radius float64 = 100000
+	var limit int = 100
+	cmdTest.AddCommand(cmdTestGeo)
+	cmdTestGeo.Flags().IntVar(&parallelism, "parallelism", parallelism, "set -parallelism to use multiple go routines")
+	cmdTestGeo.Flags().IntVar(&runTimeSeconds, "runTime", runTimeSeconds, "Run time in seconds")
+	cmdTestGeo.Flags().StringVar(&collectionName, "collection", collectionName, "Name of batch import collection.")
+	cmdTestGeo.Flags().StringVar(&queries, "queries", queries, "Comma separated list of queries to run: intersects, contains and near")
+	cmdTestGeo.Flags().Float64Var(&querySize, "query-size", querySize, "Side length in degrees of the random squares for intersects and contains")
+	cmdTestGeo.Flags().Float64Var(&radius, "radius", radius, "Radius in meters for near")
+	cmdTestGeo.Flags().IntVar(&limit, "limit", limit, "Maximal number of results of a query")
+	errorBudgetFlags(cmdTestGeo)
+	databaseFlag(cmdTestGeo)
+}
+
+// testGeo runs random geo queries in parallel.
+func testGeo(cmd *cobra.Command, _ []string) error {
+	parallelism, _ := cmd.Flags().GetInt("parallelism")
+	runTimeSeconds, _ := cmd.Flags().GetInt("runTime")
+	collectionName, _ := cmd.Flags().GetString("collection")
+	queries, _ := cmd.Flags().GetString("queries")
+	querySize, _ := cmd.Flags().GetFloat64("query-size")
+	radius, _ := cmd.Flags().GetFloat64("radius")
+	limit, _ := cmd.Flags().GetInt("limit")
+
+	kinds := strings.Split(queries, ",")
+	for _, kind := range kinds {
+		if _, ok := geoQueries[kind]; !ok {
+			return fmt.Errorf("invalid geo query: %s", kind)
+		}
+	}
+	if querySize <= 0 || querySize >= 180 {
+		return fmt.Errorf("query size must be between 0 and 180 degrees: %f", querySize)
+	}
+
+	db, err := getDatabase(cmd)
+	if err != nil {
+		return err
+	}
+
+	r := runner.New(runner.Config{
+		Name:        "testGeo",
+		Parallelism: parallelism,
+		FirstID:     1,
+		Operation:   "queries",
+		Items:       "docs",
+		ReportEvery: 1000,
+		Budget:      getErrorBudget(cmd),
+	})
+	startTime := time.Now()
+	runTime := time.Duration(runTimeSeconds) * time.Second
+	_, err = r.Run(func(ctx context.Context, w *runner.Worker) error {
+		for time.Since(startTime) < runTime && ctx.Err() == nil {
+			kind := kinds[w.Rand.Intn(len(kinds))]
+			if err := runGeoQuery(ctx, w, db, collectionName, kind, querySize, radius, limit); err != nil {
+				if err := w.Fail(err); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "can not run geo queries")
+	}
+	return nil
+}
+
+// runGeoQuery runs one geo query of the given kind at a random place.
+func runGeoQuery(ctx context.Context, w *runner.Worker, db driver.Database, collectionName string, kind string,
+	querySize float64, radius float64, limit int) error {
+	start := time.Now()
+	lon := w.Rand.Float64()*(360.0-querySize) - 180.0
+	lat := w.Rand.Float64()*(160.0-querySize) - 80.0
+	bindVars := map[string]interface{}{"@col": collectionName, "limit": limit}
+	if kind == "near" {
+		bindVars["point"] = map[string]interface{}{"type": "Point", "coordinates": Point{lon, lat}}
+		bindVars["radius"] = radius
+	} else {
+		bindVars["shape"] = makeSquare(lon, lat, querySize)
+	}
+	cursor, err := db.Query(ctx, geoQueries[kind], bindVars)
+	if err != nil {
+		w.Printf("Error running %s query: %v\n", kind, err)
+		return err
+	}
+	defer cursor.Close()
+	var count int64
+	for cursor.HasMore() {
+		var key string
+		if _, err := cursor.ReadDocument(ctx, &key); err != nil {
+			w.Printf("Error reading result of %s query: %v\n", kind, err)
+			return err
+		}
+		count++
+	}
+	w.RecordAs(kind, start, count)
+	return nil
+}
@@ -2,12 +2,14 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"math/rand"
-	"sort"
+	"io/ioutil"
+	"strings"
 	"time"
 )
 
@@ -19,28 +21,36 @@ var (
 	}
 )
 
+// traversalShape describes one kind of traversal query run by test graph.
+type traversalShape struct {
+	Name            string `json:"name,omitempty"`
+	Weight          int    `json:"weight"`
+	MinDepth        int    `json:"minDepth"`
+	MaxDepth        int    `json:"maxDepth"`
+	Direction       string `json:"direction"`                 // OUTBOUND, INBOUND or ANY
+	StartRole       string `json:"startRole"`                 // K, L or M
+	TenantCondition string `json:"tenantCondition,omitempty"` // "", "prune" or "filter"
+	Return          string `json:"return,omitempty"`          // "vertices" or "paths"
+}
+
 func init() {
 	var runTimeSeconds int
+	var minDepth, maxDepth int
+	var direction, startRole, tenantCondition, returnWhat, mixtureFile string
 
 	cmdTest.AddCommand(cmdTestGraph)
 	cmdTestGraph.Flags().IntVar(&runTimeSeconds, "runTime", 30, "Run time in seconds")
+	cmdTestGraph.Flags().IntVar(&minDepth, "min-depth", 2, "Minimal depth of the traversal")
+	cmdTestGraph.Flags().IntVar(&maxDepth, "max-depth", 2, "Maximal depth of the traversal")
+	cmdTestGraph.Flags().StringVar(&direction, "direction", "OUTBOUND", "Direction of the traversal: OUTBOUND, INBOUND or ANY")
+	cmdTestGraph.Flags().StringVar(&startRole, "start-role", "K", "Role of the start vertex in its path: K, L or M")
+	cmdTestGraph.Flags().StringVar(&tenantCondition, "tenant-condition", "",
+		"Condition on the tenantId of the visited vertices: '' (none), 'prune' or 'filter'")
+	cmdTestGraph.Flags().StringVar(&returnWhat, "return", "vertices", "What the traversal returns: 'vertices' or 'paths'")
+	cmdTestGraph.Flags().StringVar(&mixtureFile, "mixture", "",
+		"JSON file with a list of traversal shapes and their weights, replaces the single shape given by the other flags")
 	commonGraphFlags(cmdTestGraph)
-}
-
-type DurationSlice []time.Duration
-
-func (d DurationSlice) Len() int {
-	return len(d)
-}
-
-func (d DurationSlice) Less(a, b int) bool {
-	return d[a] < d[b]
-}
-
-func (d DurationSlice) Swap(a, b int) {
-	var dummy time.Duration = d[a]
-	d[a] = d[b]
-	d[b] = dummy
+	errorBudgetFlags(cmdTestGraph)
 }
 
 func testGraph(cmd *cobra.Command, _ []string) error {
@@ -49,61 +59,222 @@ func testGraph(cmd *cobra.Command, _ []string) error {
 	nrPathsPerTenant, _ := cmd.Flags().GetInt("nrPathsPerTenant")
 	parallelism, _ := cmd.Flags().GetInt("parallelism")
 	runTimeSeconds, _ := cmd.Flags().GetInt("runTime")
+	vertexCollectionName, _ := cmd.Flags().GetString("vertex-collection")
+	graphName, _ := cmd.Flags().GetString("graph")
+	mixtureFile, _ := cmd.Flags().GetString("mixture")
+
+	var shapes []traversalShape
+	if mixtureFile != "" {
+		var err error
+		if shapes, err = readTraversalMixture(mixtureFile); err != nil {
+			return err
+		}
+	} else {
+		minDepth, _ := cmd.Flags().GetInt("min-depth")
+		maxDepth, _ := cmd.Flags().GetInt("max-depth")
+		direction, _ := cmd.Flags().GetString("direction")
+		startRole, _ := cmd.Flags().GetString("start-role")
+		tenantCondition, _ := cmd.Flags().GetString("tenant-condition")
+		returnWhat, _ := cmd.Flags().GetString("return")
+		shapes = []traversalShape{{
+			Weight:          1,
+			MinDepth:        minDepth,
+			MaxDepth:        maxDepth,
+			Direction:       direction,
+			StartRole:       startRole,
+			TenantCondition: tenantCondition,
+			Return:          returnWhat,
+		}}
+	}
+	for i := range shapes {
+		if err := shapes[i].validate(); err != nil {
+			return err
+		}
+	}
 
-	db, err := _client.Database(context.Background(), "_system")
+	db, err := getDatabase(cmd)
 	if err != nil {
-		return errors.Wrapf(err, "can not get database: %s", "_system")
+		return err
 	}
 
-	return runRandomTest(db, runTimeSeconds, firstTenant, lastTenant, nrPathsPerTenant, parallelism)
+	return runRandomTest(db, runTimeSeconds, firstTenant, lastTenant, nrPathsPerTenant, parallelism, graphName,
+		vertexCollectionName, shapes, getErrorBudget(cmd))
+}
 
+// readTraversalMixture reads a JSON list of traversal shapes.
+func readTraversalMixture(filename string) ([]traversalShape, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not read mixture file: %s", filename)
+	}
+	var shapes []traversalShape
+	if err := json.Unmarshal(content, &shapes); err != nil {
+		return nil, errors.Wrapf(err, "can not parse mixture file: %s", filename)
+	}
+	if len(shapes) == 0 {
+		return nil, fmt.Errorf("mixture file %s contains no traversal shapes", filename)
+	}
+	return shapes, nil
 }
 
+// validate checks the shape and fills in defaults.
+func (t *traversalShape) validate() error {
+	t.Direction = strings.ToUpper(t.Direction)
+	if t.Direction != "OUTBOUND" && t.Direction != "INBOUND" && t.Direction != "ANY" {
+		return fmt.Errorf("invalid direction: %s", t.Direction)
+	}
+	t.StartRole = strings.ToUpper(t.StartRole)
+	if len(t.StartRole) != 1 || !strings.Contains("KLM", t.StartRole) {
+		return fmt.Errorf("invalid start role: %s", t.StartRole)
+	}
+	if t.MinDepth < 0 || t.MaxDepth < t.MinDepth {
+		return fmt.Errorf("invalid depth range: %d..%d", t.MinDepth, t.MaxDepth)
+	}
+	t.TenantCondition = strings.ToLower(t.TenantCondition)
+	if t.TenantCondition != "" && t.TenantCondition != "prune" && t.TenantCondition != "filter" {
+		return fmt.Errorf("invalid tenant condition: %s", t.TenantCondition)
+	}
+	if t.Return == "" {
+		t.Return = "vertices"
+	}
+	if t.Return != "vertices" && t.Return != "paths" {
+		return fmt.Errorf("invalid return: %s", t.Return)
+	}
+	if t.Weight <= 0 {
+		return fmt.Errorf("weight of traversal shape must be positive: %d", t.Weight)
+	}
+	if t.Name == "" {
+		t.Name = fmt.Sprintf("%d..%d %s from %s", t.MinDepth, t.MaxDepth, t.Direction, t.StartRole)
+		if t.TenantCondition != "" {
+			t.Name += " " + t.TenantCondition
+		}
+		t.Name += " returning " + t.Return
+	}
+	return nil
+}
+
+// query returns the AQL traversal from `startVertex` of tenant `tenantId`.
+func (t *traversalShape) query(startVertex string, tenantId string, graphName string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `FOR v, e, p IN %d..%d %s "%s" GRAPH "%s"`,
+		t.MinDepth, t.MaxDepth, t.Direction, startVertex, graphName)
+	switch t.TenantCondition {
+	case "prune":
+		fmt.Fprintf(&b, ` PRUNE v.tenantId != "%s"`, tenantId)
+	case "filter":
+		fmt.Fprintf(&b, ` FILTER v.tenantId == "%s"`, tenantId)
+	}
+	if t.Return == "paths" {
+		b.WriteString(" RETURN p")
+	} else {
+		b.WriteString(" RETURN v")
+	}
+	return b.String()
+}
+
+// expectedCount returns the number of results of the traversal on the
+// K -> L -> M paths written by create graph. Every path is found once at
+// each depth at which it fits into the chain from the start vertex.
+func (t *traversalShape) expectedCount() int {
+	pos := strings.Index("KLM", t.StartRole)
+	count := 0
+	for d := t.MinDepth; d <= t.MaxDepth; d++ {
+		if d == 0 {
+			count++
+			continue
+		}
+		if t.Direction != "INBOUND" && pos+d <= 2 {
+			count++
+		}
+		if t.Direction != "OUTBOUND" && pos-d >= 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// pickTraversalShape chooses one of the shapes according to their weights.
+func pickTraversalShape(w *runner.Worker, shapes []traversalShape, totalWeight int) *traversalShape {
+	x := w.Rand.Intn(totalWeight)
+	for i := range shapes {
+		if x < shapes[i].Weight {
+			return &shapes[i]
+		}
+		x -= shapes[i].Weight
+	}
+	return &shapes[len(shapes)-1]
+}
+
+// runRandomTest runs random traversals on `parallelism` go routines for
+// `runTimeSeconds` seconds.
 func runRandomTest(db driver.Database, runTimeSeconds int, firstTenantNr int, lastTenantNr int,
-	pathsPerTenant int, parallelism int) error {
-	// parallelism ignored so far!
+	pathsPerTenant int, parallelism int, graphName string, vertexCollectionName string,
+	shapes []traversalShape, budget runner.ErrorBudget) error {
+	r := runner.New(runner.Config{
+		Name:        "runRandomTest",
+		Parallelism: parallelism,
+		FirstID:     1,
+		Operation:   "queries",
+		ReportEvery: 1000,
+		Budget:      budget,
+	})
+	totalWeight := 0
+	for _, shape := range shapes {
+		totalWeight += shape.Weight
+	}
 	startTime := time.Now()
 	limit := time.Duration(runTimeSeconds) * time.Second
-	for time.Now().Sub(startTime) < limit {
-		// Run another 1000 random access queries:
-		times := make([]time.Duration, 0, 1000)
-		for i := 1; i <= 1000; i++ {
-			start := time.Now()
-			startVertex := fmt.Sprintf(
-				"instances/ten%d:K%d",
-				firstTenantNr+rand.Intn(lastTenantNr+1-firstTenantNr),
-				rand.Intn(pathsPerTenant)+1)
-			query := fmt.Sprintf(
-				`FOR v, e IN 2..2 OUTBOUND "%s" GRAPH "G" RETURN v`,
-				startVertex)
-			cursor, err := db.Query(nil, query, nil)
+	_, err := r.Run(func(ctx context.Context, w *runner.Worker) error {
+		for time.Since(startTime) < limit && ctx.Err() == nil {
+			shape := pickTraversalShape(w, shapes, totalWeight)
+			err := runRandomQuery(ctx, w, db, firstTenantNr, lastTenantNr, pathsPerTenant, graphName, vertexCollectionName, shape)
 			if err != nil {
-				fmt.Printf("Error running query: %v\n", err)
-				return err
-			}
-			count := 0
-			for cursor.HasMore() {
-				var vertex Instance
-				_, err := cursor.ReadDocument(nil, &vertex)
-				if err != nil {
-					fmt.Printf("Error reading document from cursor: %v\n", err)
+				// Wrong results are counted, without a budget they do not
+				// stop the run:
+				err := w.Fail(err)
+				if err != nil && (budget.Enabled() || errors.Cause(err) != runner.ErrWrongResult) {
 					return err
 				}
-				count = count + 1
 			}
-			cursor.Close()
-			times = append(times, time.Now().Sub(start))
-			if count != 1 {
-				fmt.Printf("Got wrong count: %d, key: %s, query: %s\n", count, startVertex, query)
-			}
-			cursor.Close()
 		}
-		sort.Sort(DurationSlice(times))
-		var sum int64 = 0
-		for _, t := range times {
-			sum = sum + int64(t)
+		return nil
+	})
+	return err
+}
+
+// runRandomQuery runs one traversal of the given shape from a random vertex
+// and checks the number of results.
+func runRandomQuery(ctx context.Context, w *runner.Worker, db driver.Database, firstTenantNr int, lastTenantNr int,
+	pathsPerTenant int, graphName string, vertexCollectionName string, shape *traversalShape) error {
+	start := time.Now()
+	tenantId := fmt.Sprintf("ten%d", firstTenantNr+w.Rand.Intn(lastTenantNr+1-firstTenantNr))
+	startVertex := fmt.Sprintf(
+		"%s/%s:%s%d",
+		vertexCollectionName,
+		tenantId,
+		shape.StartRole,
+		w.Rand.Intn(pathsPerTenant)+1)
+	query := shape.query(startVertex, tenantId, graphName)
+	cursor, err := db.Query(ctx, query, nil)
+	if err != nil {
+		w.Printf("Error running query: %v\n", err)
+		return err
+	}
+	defer cursor.Close()
+	count := 0
+	for cursor.HasMore() {
+		var result json.RawMessage
+		_, err := cursor.ReadDocument(ctx, &result)
+		if err != nil {
+			w.Printf("Error reading document from cursor: %v\n", err)
+			return err
 		}
-		fmt.Printf("Times for last 1000: %s (median), %s (90%%ile), %s (99%%ilie), %s (average)\n", times[500], times[900], times[990], time.Duration(sum/1000))
+		count = count + 1
+	}
+	if expected := shape.expectedCount(); count != expected {
+		w.Printf("Got wrong count: %d, expected: %d, key: %s, query: %s\n", count, expected, startVertex, query)
+		return errors.Wrapf(runner.ErrWrongResult, "got %d instead of %d results from %s", count, expected, startVertex)
 	}
+	w.RecordAs(shape.Name, start, 1)
 	return nil
 }
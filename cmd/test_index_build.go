@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/database"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	cmdTestIndexBuild = &cobra.Command{
+		Use:   "index-build",
+		Short: "Measure how long building indexes takes and how it affects concurrent writes",
+		RunE:  testIndexBuild,
+	}
+)
+
+// indexBuildPhases are the phases of test index-build, the writes are
+// reported separately for each.
+var indexBuildPhases = []string{"before", "during", "after"}
+
+// indexBuild is the state of test index-build shared by all go routines.
+type indexBuild struct {
+	collectionName string
+	specs          []database.IndexSpec
+	batchSize      int64
+	payloadSize    int64
+	withWords      int
+	keySize        int
+	next           int64 // number of the next document to write, accessed atomically
+	phase          int32 // index into indexBuildPhases, accessed atomically
+	done           int32 // set to 1 when the writers should stop, accessed atomically
+	phaseTimes     [3]time.Duration
+	ids            []string
+}
+
+func init() {
+	var parallelism int = 4
+	var collectionName string = "batchimport"
+	var batchSize int64 = 100
+	var payloadSize int64 = 100
+	var withWords int = 0
+	var beforeSeconds int = 10
+	var afterSeconds int = 10
+	var inBackground bool = false
+	var dropIndexes bool = true
+	cmdTest.AddCommand(cmdTestIndexBuild)
+	cmdTestIndexBuild.Flags().IntVar(&parallelism, "parallelism", parallelism, "Number of go routines writing documents")
+	cmdTestIndexBuild.Flags().StringVar(&collectionName, "collection", collectionName, "Name of populated batch import collection.")
+	cmdTestIndexBuild.Flags().Int64Var(&batchSize, "batch-size", batchSize, "Number of documents written per request.")
+	cmdTestIndexBuild.Flags().Int64Var(&payloadSize, "payload-size", payloadSize, "Size of the payload of a document.")
+	cmdTestIndexBuild.Flags().IntVar(&withWords, "with-words", withWords, "Add so many words to `words` attribute.")
+	cmdTestIndexBuild.Flags().IntVar(&beforeSeconds, "before", beforeSeconds, "Seconds to write before the indexes are built")
+	cmdTestIndexBuild.Flags().IntVar(&afterSeconds, "after", afterSeconds, "Seconds to write after the indexes are built")
+	cmdTestIndexBuild.Flags().BoolVar(&inBackground, "in-background", inBackground, "set -in-background to build all indexes in background")
+	cmdTestIndexBuild.Flags().BoolVar(&dropIndexes, "drop-indexes", dropIndexes, "Drop the built indexes at the end, so that the test can be repeated")
+	indexesFlag(cmdTestIndexBuild)
+	errorBudgetFlags(cmdTestIndexBuild)
+	databaseFlag(cmdTestIndexBuild)
+}
+
+// testIndexBuild writes documents in the format of write batchimport into a
+// populated collection and builds the indexes given with --indexes while
+// the writes go on. The writes before, during and after the build are
+// reported separately.
+func testIndexBuild(cmd *cobra.Command, _ []string) error {
+	parallelism, _ := cmd.Flags().GetInt("parallelism")
+	beforeSeconds, _ := cmd.Flags().GetInt("before")
+	afterSeconds, _ := cmd.Flags().GetInt("after")
+	inBackground, _ := cmd.Flags().GetBool("in-background")
+	dropIndexes, _ := cmd.Flags().GetBool("drop-indexes")
+
+	b := indexBuild{}
+	b.collectionName, _ = cmd.Flags().GetString("collection")
+	b.batchSize, _ = cmd.Flags().GetInt64("batch-size")
+	b.payloadSize, _ = cmd.Flags().GetInt64("payload-size")
+	b.withWords, _ = cmd.Flags().GetInt("with-words")
+	if parallelism < 1 || b.batchSize < 1 {
+		return fmt.Errorf("parallelism and batch size must be positive")
+	}
+	specs, err := getIndexSpecs(cmd)
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		if spec.Collection == "" || spec.Collection == b.collectionName {
+			spec.InBackground = spec.InBackground || inBackground
+			b.specs = append(b.specs, spec)
+		}
+	}
+	if len(b.specs) == 0 {
+		return fmt.Errorf("no indexes for collection `%s` given with --indexes", b.collectionName)
+	}
+
+	db, err := getDatabase(cmd)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	coll, err := db.Collection(ctx, b.collectionName)
+	if err != nil {
+		fmt.Printf("Could not open `%s` collection: %v\n", b.collectionName, err)
+		return err
+	}
+	// New documents continue the key space of write batchimport:
+	if b.next, b.keySize, err = batchImportKeySpace(ctx, coll, 0, 0); err != nil {
+		return err
+	}
+	first := b.next
+	count, err := countBatchImportDocuments(ctx, coll)
+	if err != nil {
+		return errors.Wrapf(err, "can not count documents")
+	}
+	fmt.Printf("Building %d indexes on `%s` with %d documents.\n", len(b.specs), b.collectionName, count)
+
+	r := runner.New(runner.Config{
+		Name:        "testIndexBuild",
+		Parallelism: parallelism + 1,
+		Operation:   "batches",
+		Items:       "docs",
+		Budget:      getErrorBudget(cmd),
+	})
+	_, err = r.Run(func(ctx context.Context, w *runner.Worker) error {
+		if w.ID == 0 {
+			defer atomic.StoreInt32(&b.done, 1)
+			return b.buildIndexes(ctx, w, db, time.Duration(beforeSeconds)*time.Second,
+				time.Duration(afterSeconds)*time.Second)
+		}
+		return b.writeDocuments(ctx, w, coll)
+	})
+	b.print(r)
+	if last := atomic.LoadInt64(&b.next); last > first {
+		if err := writeBatchImportMetadata(ctx, coll, last, b.keySize); err != nil {
+			fmt.Printf("Could not write metadata document: %v\n", err)
+		}
+	}
+	if dropIndexes {
+		for _, id := range b.ids {
+			if err := database.DropIndex(ctx, _client.Connection(), db, id); err != nil {
+				fmt.Printf("Could not drop index %s: %v\n", id, err)
+			}
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "can not build indexes")
+	}
+	return nil
+}
+
+// buildIndexes waits `before`, builds the indexes one after the other and
+// then waits `after`, switching the phase of the writers in between.
+func (b *indexBuild) buildIndexes(ctx context.Context, w *runner.Worker, db driver.Database,
+	before time.Duration, after time.Duration) error {
+	sleep := func(d time.Duration) time.Duration {
+		start := time.Now()
+		select {
+		case <-ctx.Done():
+		case <-time.After(d):
+		}
+		return time.Since(start)
+	}
+	b.phaseTimes[0] = sleep(before)
+	atomic.StoreInt32(&b.phase, 1)
+	start := time.Now()
+	for _, spec := range b.specs {
+		id, created, took, err := database.EnsureIndex(ctx, _client.Connection(), db, b.collectionName, spec)
+		if err != nil {
+			w.Printf("testIndexBuild: could not create %s: %v\n", spec.String(), err)
+			return err
+		}
+		if !created {
+			return fmt.Errorf("%s exists already on `%s`, drop it first", spec.String(), b.collectionName)
+		}
+		b.ids = append(b.ids, id)
+		b.phaseTimes[1] = time.Since(start)
+		w.Printf("Built %s in %v.\n", spec.String(), took)
+	}
+	atomic.StoreInt32(&b.phase, 2)
+	b.phaseTimes[2] = sleep(after)
+	return nil
+}
+
+// writeDocuments writes batches of new documents until the index build is
+// done and records each batch as an operation of the current phase.
+func (b *indexBuild) writeDocuments(ctx context.Context, w *runner.Worker, coll driver.Collection) error {
+	docs := make([]Doc, 0, b.batchSize)
+	for atomic.LoadInt32(&b.done) == 0 && ctx.Err() == nil {
+		phase := indexBuildPhases[atomic.LoadInt32(&b.phase)]
+		start := time.Now()
+		first := atomic.AddInt64(&b.next, b.batchSize) - b.batchSize
+		for which := first; which < first+b.batchSize; which++ {
+			var words string
+			if b.withWords > 0 {
+				words = makeRandomWords(b.withWords, w.Rand)
+			}
+			docs = append(docs, Doc{
+				Key:     batchImportKey(which, b.keySize),
+				Sha:     batchImportSha(which),
+				Payload: makeRandomStringWithSpaces(int(b.payloadSize), w.Rand),
+				Words:   words,
+			})
+		}
+		ctx2, cancel := context.WithTimeout(driver.WithOverwriteMode(ctx, driver.OverwriteModeReplace), time.Hour)
+		_, _, err := coll.CreateDocuments(ctx2, docs)
+		cancel()
+		docs = docs[0:0]
+		if err != nil {
+			w.Printf("testIndexBuild: could not write batch: %v\n", err)
+			if err := w.Fail(err); err != nil {
+				return err
+			}
+			continue
+		}
+		w.RecordAs(phase, start, b.batchSize)
+	}
+	return nil
+}
+
+// print prints the write latencies and rates of each phase, which the
+// runner only relates to the total run time.
+func (b *indexBuild) print(r *runner.Runner) {
+	fmt.Printf("\nWrites by phase of the index build:\n")
+	for i, phase := range indexBuildPhases {
+		s := r.KindStats(phase)
+		if s.Count() == 0 || b.phaseTimes[i] == 0 {
+			fmt.Printf("  %s: no writes\n", phase)
+			continue
+		}
+		fmt.Printf("  %s (%v): %d batches, %.1f docs per second, %s\n", phase, b.phaseTimes[i],
+			s.Count(), runner.PerSecond(s.Items, b.phaseTimes[i]), s.Latencies())
+	}
+}
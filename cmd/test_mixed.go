@@ -0,0 +1,345 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/distribution"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	cmdTestMixed = &cobra.Command{
+		Use:   "mixed",
+		Short: "Run a mixed read/write workload (YCSB core workloads A-F) on the batchimport collection",
+		RunE:  testMixed,
+	}
+)
+
+// mixedOperations are the operations of the YCSB core workloads.
+var mixedOperations = []string{"read", "update", "insert", "scan", "read-modify-write"}
+
+// mixedWorkload gives the proportions of the operations and the default key
+// distribution of a workload.
+type mixedWorkload struct {
+	proportions  map[string]int
+	distribution string
+}
+
+// ycsbWorkloads are the YCSB core workloads.
+var ycsbWorkloads = map[string]mixedWorkload{
+	"A": {map[string]int{"read": 50, "update": 50}, "zipfian"},
+	"B": {map[string]int{"read": 95, "update": 5}, "zipfian"},
+	"C": {map[string]int{"read": 100}, "zipfian"},
+	"D": {map[string]int{"read": 95, "insert": 5}, "latest"},
+	"E": {map[string]int{"scan": 95, "insert": 5}, "zipfian"},
+	"F": {map[string]int{"read": 50, "read-modify-write": 50}, "zipfian"},
+}
+
+// mixedTest is the state shared by all go routines of test mixed.
+type mixedTest struct {
+	collectionName string
+	totalNumber    int64 // documents 0 to totalNumber-1 are read and updated
+	firstInsert    int64 // number of the first inserted document, after all written by write batchimport
+	keySize        int
+	payloadSize    int64
+	maxScanLength  int64
+	proportions    map[string]int
+	totalWeight    int
+	keys           distribution.Config
+	allocated      int64 // number of inserts begun, accessed atomically
+	contiguous     int64 // number of inserts finished without a gap, accessed atomically
+	failedInserts  int64 // accessed atomically
+	mutex          sync.Mutex
+	finished       map[int64]bool // inserts finished after a gap
+	failed         map[int64]bool // inserts which failed, their documents are not chosen
+}
+
+func init() {
+	var parallelism int = 4
+	var startDelay int64 = 5
+	var number int64 = 100000
+	var totalNumber int64 = 0
+	var collectionName string = "batchimport"
+	var workload string = "A"
+	var proportions string = ""
+	var keySize int = 0
+	var payloadSize int64 = 10
+	var maxScanLength int64 = 100
+	cmdTest.AddCommand(cmdTestMixed)
+	cmdTestMixed.Flags().IntVar(&parallelism, "parallelism", parallelism, "set -parallelism to use multiple go routines")
+	cmdTestMixed.Flags().Int64Var(&number, "number", number, "set -number for number of operations per go routine")
+	cmdTestMixed.Flags().Int64Var(&totalNumber, "total-number", totalNumber, "Number of documents written by write batchimport, 0 takes it from its metadata or the collection count")
+	cmdTestMixed.Flags().Int64Var(&startDelay, "start-delay", startDelay, "Delay between the start of two go routines.")
+	cmdTestMixed.Flags().StringVar(&collectionName, "collection", collectionName, "Name of batch import collection.")
+	cmdTestMixed.Flags().StringVar(&workload, "workload", workload, "YCSB core workload: A, B, C, D, E or F")
+	cmdTestMixed.Flags().StringVar(&proportions, "proportions", proportions,
+		"Proportions of the operations which replace the ones of the workload, e.g. 'read:90,update:5,insert:5'")
+	cmdTestMixed.Flags().IntVar(&keySize, "key-size", keySize, "Key size used by write batchimport, 0 takes it from its metadata")
+	cmdTestMixed.Flags().Int64Var(&payloadSize, "payload-size", payloadSize, "Size in bytes of payload of updated and inserted documents.")
+	cmdTestMixed.Flags().Int64Var(&maxScanLength, "max-scan-length", maxScanLength, "Maximal number of documents read by a scan.")
+	keyDistributionFlags(cmdTestMixed, "")
+	errorBudgetFlags(cmdTestMixed)
+	databaseFlag(cmdTestMixed)
+}
+
+// testMixed runs a mixed workload in parallel
+func testMixed(cmd *cobra.Command, _ []string) error {
+	parallelism, _ := cmd.Flags().GetInt("parallelism")
+	number, _ := cmd.Flags().GetInt64("number")
+	startDelay, _ := cmd.Flags().GetInt64("start-delay")
+	workloadName, _ := cmd.Flags().GetString("workload")
+	proportions, _ := cmd.Flags().GetString("proportions")
+
+	totalNumber, _ := cmd.Flags().GetInt64("total-number")
+	keySize, _ := cmd.Flags().GetInt("key-size")
+
+	t := mixedTest{finished: make(map[int64]bool), failed: make(map[int64]bool)}
+	t.collectionName, _ = cmd.Flags().GetString("collection")
+	t.payloadSize, _ = cmd.Flags().GetInt64("payload-size")
+	t.maxScanLength, _ = cmd.Flags().GetInt64("max-scan-length")
+	t.keys = getKeyDistribution(cmd)
+	if t.maxScanLength < 1 {
+		return fmt.Errorf("max scan length must be positive: %d", t.maxScanLength)
+	}
+
+	workload, ok := ycsbWorkloads[strings.ToUpper(workloadName)]
+	if !ok {
+		return fmt.Errorf("invalid workload: %s", workloadName)
+	}
+	t.proportions = workload.proportions
+	if proportions != "" {
+		var err error
+		if t.proportions, err = parseProportions(proportions); err != nil {
+			return err
+		}
+	}
+	for _, p := range t.proportions {
+		t.totalWeight += p
+	}
+	if t.totalWeight <= 0 {
+		return fmt.Errorf("proportions must add up to a positive number")
+	}
+	if t.keys.Name == "" {
+		t.keys.Name = workload.distribution
+	}
+	// Check the distribution once before the go routines start:
+	if _, err := t.keys.New(); err != nil {
+		return err
+	}
+
+	db, err := getDatabase(cmd)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	coll, err := db.Collection(ctx, t.collectionName)
+	if err != nil {
+		fmt.Printf("Could not open `%s` collection: %v\n", t.collectionName, err)
+		return err
+	}
+	if t.totalNumber, t.keySize, err = batchImportKeySpace(ctx, coll, totalNumber, keySize); err != nil {
+		return err
+	}
+	// Inserts continue after all documents written so far, also if only
+	// some of them are read:
+	t.firstInsert = t.totalNumber
+	if meta, err := readBatchImportMetadata(ctx, coll); err == nil && meta != nil && meta.TotalNumber > t.firstInsert {
+		t.firstInsert = meta.TotalNumber
+	}
+
+	r := runner.New(runner.Config{
+		Name:        "testMixed",
+		Parallelism: parallelism,
+		FirstID:     1,
+		StartDelay:  time.Duration(startDelay) * time.Millisecond,
+		Operation:   "operations",
+		Items:       "docs",
+		ReportEvery: 100000,
+		Budget:      getErrorBudget(cmd),
+	})
+	_, err = r.Run(func(ctx context.Context, w *runner.Worker) error {
+		return t.run(ctx, w, number, db)
+	})
+	if t.allocated > 0 {
+		fmt.Printf("Inserted documents %d to %d, %d inserts failed.\n",
+			t.firstInsert, t.firstInsert+t.contiguous-1, t.failedInserts)
+		if err := writeBatchImportMetadata(ctx, coll, t.firstInsert+t.contiguous, t.keySize); err != nil {
+			fmt.Printf("Could not write metadata document: %v\n", err)
+			return err
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "can not run mixed workload")
+	}
+	return nil
+}
+
+// parseProportions parses proportions like "read:90,update:5,insert:5".
+func parseProportions(s string) (map[string]int, error) {
+	proportions := make(map[string]int)
+	for _, part := range strings.Split(s, ",") {
+		nameAndWeight := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(nameAndWeight) != 2 {
+			return nil, fmt.Errorf("invalid proportion: %s", part)
+		}
+		name := nameAndWeight[0]
+		known := false
+		for _, op := range mixedOperations {
+			known = known || op == name
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid operation: %s", name)
+		}
+		weight, err := strconv.Atoi(nameAndWeight[1])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid proportion: %s", part)
+		}
+		proportions[name] = weight
+	}
+	return proportions, nil
+}
+
+// pickOperation chooses an operation according to the proportions.
+func (t *mixedTest) pickOperation(w *runner.Worker) string {
+	x := w.Rand.Intn(t.totalWeight)
+	for _, op := range mixedOperations {
+		if x < t.proportions[op] {
+			return op
+		}
+		x -= t.proportions[op]
+	}
+	return mixedOperations[0]
+}
+
+// run does `nrOps` random operations.
+func (t *mixedTest) run(ctx context.Context, w *runner.Worker, nrOps int64, db driver.Database) error {
+	coll, err := db.Collection(ctx, t.collectionName)
+	if err != nil {
+		w.Printf("testMixed: could not open `%s` collection: %v\n", t.collectionName, err)
+		return err
+	}
+	keys, err := t.keys.New()
+	if err != nil {
+		return err
+	}
+	for i := int64(1); i <= nrOps && ctx.Err() == nil; i++ {
+		op := t.pickOperation(w)
+		key := batchImportKey(t.pick(w, keys), t.keySize)
+		start := time.Now()
+		var items int64 = 1
+		ctx2, cancel := context.WithTimeout(ctx, time.Hour)
+		switch op {
+		case "read":
+			var doc Doc
+			_, err = coll.ReadDocument(ctx2, key, &doc)
+		case "update":
+			_, err = coll.UpdateDocument(ctx2, key, t.update(w))
+		case "insert":
+			offset := atomic.AddInt64(&t.allocated, 1) - 1
+			key = batchImportKey(t.firstInsert+offset, t.keySize)
+			_, err = coll.CreateDocument(ctx2, t.newDoc(w, t.firstInsert+offset))
+			t.finishInsert(offset, err == nil)
+		case "scan":
+			items, err = t.scan(ctx2, db, key, 1+w.Rand.Int63n(t.maxScanLength))
+		case "read-modify-write":
+			var doc Doc
+			if _, err = coll.ReadDocument(ctx2, key, &doc); err == nil {
+				_, err = coll.UpdateDocument(ctx2, key, t.update(w))
+			}
+		}
+		cancel()
+		if err != nil {
+			w.Printf("testMixed: could not %s document %s: %v\n", op, key, err)
+			if err := w.Fail(err); err != nil {
+				return err
+			}
+			continue
+		}
+		w.RecordAs(op, start, items)
+	}
+	return nil
+}
+
+// pick chooses the number of a document to read, update or scan from. Only
+// documents whose insert and all inserts before have finished are chosen,
+// so that documents which do not exist yet are never read.
+func (t *mixedTest) pick(w *runner.Worker, keys distribution.Distribution) int64 {
+	contiguous := atomic.LoadInt64(&t.contiguous)
+	i := keys.Next(w.Rand, t.totalNumber+contiguous)
+	if i < t.totalNumber {
+		return i
+	}
+	offset := i - t.totalNumber
+	if atomic.LoadInt64(&t.failedInserts) > 0 {
+		t.mutex.Lock()
+		for offset >= 0 && t.failed[offset] {
+			offset--
+		}
+		t.mutex.Unlock()
+		if offset < 0 {
+			return w.Rand.Int63n(t.totalNumber)
+		}
+	}
+	return t.firstInsert + offset
+}
+
+// finishInsert records that insert number `offset` has finished and
+// advances the contiguous inserts. A failed insert closes its gap, but
+// its document is not chosen.
+func (t *mixedTest) finishInsert(offset int64, ok bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.finished[offset] = true
+	if !ok {
+		t.failed[offset] = true
+		atomic.AddInt64(&t.failedInserts, 1)
+	}
+	contiguous := t.contiguous
+	for t.finished[contiguous] {
+		delete(t.finished, contiguous)
+		contiguous++
+	}
+	atomic.StoreInt64(&t.contiguous, contiguous)
+}
+
+// update returns a new payload for an existing document.
+func (t *mixedTest) update(w *runner.Worker) map[string]interface{} {
+	return map[string]interface{}{"payload": makeRandomStringWithSpaces(int(t.payloadSize), w.Rand)}
+}
+
+// newDoc returns document number `which` in the layout of write batchimport.
+func (t *mixedTest) newDoc(w *runner.Worker, which int64) Doc {
+	return Doc{
+		Key:     batchImportKey(which, t.keySize),
+		Sha:     batchImportSha(which),
+		Payload: makeRandomStringWithSpaces(int(t.payloadSize), w.Rand),
+	}
+}
+
+// scan reads up to `length` documents in key order starting at `key` and
+// returns the number of documents read.
+func (t *mixedTest) scan(ctx context.Context, db driver.Database, key string, length int64) (int64, error) {
+	cursor, err := db.Query(ctx, "FOR d IN @@col FILTER d._key >= @key SORT d._key LIMIT @length RETURN d",
+		map[string]interface{}{"@col": t.collectionName, "key": key, "length": length})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+	var count int64
+	for cursor.HasMore() {
+		var doc Doc
+		if _, err := cursor.ReadDocument(ctx, &doc); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	cmdTestPaths = &cobra.Command{
+		Use:   "paths",
+		Short: "Test shortest path and k-paths queries",
+		RunE:  testPaths,
+	}
+)
+
+// pathGraph describes the graph on which `test paths` runs its queries and
+// how source and target vertices are chosen.
+type pathGraph struct {
+	graphType        string // "tenants" (create graph) or "parts" (create smartgraph)
+	graphName        string
+	vertexCollection string
+	firstTenant      int
+	lastTenant       int
+	nrPathsPerTenant int
+	numberOfParts    int
+	nrVertices       uint64
+}
+
+func init() {
+	var graphType, queryType, graphName, vertexCollectionName string
+	var runTimeSeconds, parallelism, k, minDepth, maxDepth int
+	var firstTenant, lastTenant, nrPathsPerTenant, numberOfParts, log2NumberOfVertices int
+
+	cmdTest.AddCommand(cmdTestPaths)
+	cmdTestPaths.Flags().StringVar(&graphType, "graph-type", "tenants",
+		"Graph to run on: 'tenants' (from create graph) or 'parts' (from create smartgraph)")
+	cmdTestPaths.Flags().StringVar(&queryType, "query", "SHORTEST_PATH",
+		"Path query to run: SHORTEST_PATH, K_SHORTEST_PATHS or K_PATHS")
+	cmdTestPaths.Flags().IntVar(&k, "k", 3, "Maximal number of paths returned by K_SHORTEST_PATHS")
+	cmdTestPaths.Flags().IntVar(&minDepth, "min-depth", 1, "Minimal path length for K_PATHS")
+	cmdTestPaths.Flags().IntVar(&maxDepth, "max-depth", 4, "Maximal path length for K_PATHS")
+	cmdTestPaths.Flags().IntVar(&runTimeSeconds, "runTime", 30, "Run time in seconds")
+	cmdTestPaths.Flags().IntVar(&parallelism, "parallelism", 4, "Parallelism")
+	cmdTestPaths.Flags().StringVar(&graphName, "graph", "",
+		"Name of graph, default is 'G' for tenants and 'SmartGraph' for parts")
+	cmdTestPaths.Flags().StringVar(&vertexCollectionName, "vertex-collection", "",
+		"Name of vertex collection, default is 'instances' for tenants and 'vertices' for parts")
+	cmdTestPaths.Flags().IntVar(&firstTenant, "firstTenant", defaultFirstTenant, "Index of first tenant")
+	cmdTestPaths.Flags().IntVar(&lastTenant, "lastTenant", defaultLastTenant, "Index of last tenant")
+	cmdTestPaths.Flags().IntVar(&nrPathsPerTenant, "nrPathsPerTenant", defaultNrPathsPerTenant, "Number of paths per tenant")
+	cmdTestPaths.Flags().IntVar(&numberOfParts, "numberOfParts", defaultNumberOfParts, "Number of parts of graph")
+	cmdTestPaths.Flags().IntVar(&log2NumberOfVertices, "log2NumberOfVertices", defaultLog2NumberOfVertices,
+		"Log 2 of number of vertices in each part")
+	databaseFlag(cmdTestPaths)
+	errorBudgetFlags(cmdTestPaths)
+}
+
+func testPaths(cmd *cobra.Command, _ []string) error {
+	queryType, _ := cmd.Flags().GetString("query")
+	k, _ := cmd.Flags().GetInt("k")
+	minDepth, _ := cmd.Flags().GetInt("min-depth")
+	maxDepth, _ := cmd.Flags().GetInt("max-depth")
+	runTimeSeconds, _ := cmd.Flags().GetInt("runTime")
+	parallelism, _ := cmd.Flags().GetInt("parallelism")
+	log2NumberOfVertices, _ := cmd.Flags().GetInt("log2NumberOfVertices")
+
+	g := pathGraph{}
+	g.graphType, _ = cmd.Flags().GetString("graph-type")
+	g.graphName, _ = cmd.Flags().GetString("graph")
+	g.vertexCollection, _ = cmd.Flags().GetString("vertex-collection")
+	g.firstTenant, _ = cmd.Flags().GetInt("firstTenant")
+	g.lastTenant, _ = cmd.Flags().GetInt("lastTenant")
+	g.nrPathsPerTenant, _ = cmd.Flags().GetInt("nrPathsPerTenant")
+	g.numberOfParts, _ = cmd.Flags().GetInt("numberOfParts")
+	g.nrVertices = uint64(1) << uint(log2NumberOfVertices)
+
+	switch g.graphType {
+	case "tenants":
+		if g.graphName == "" {
+			g.graphName = "G"
+		}
+		if g.vertexCollection == "" {
+			g.vertexCollection = "instances"
+		}
+	case "parts":
+		if g.graphName == "" {
+			g.graphName = "SmartGraph"
+		}
+		if g.vertexCollection == "" {
+			g.vertexCollection = "vertices"
+		}
+	default:
+		return fmt.Errorf("invalid graph type: %s", g.graphType)
+	}
+
+	queryType = strings.ToUpper(queryType)
+	var query string
+	switch queryType {
+	case "SHORTEST_PATH":
+		query = `LET p = (FOR v IN ANY SHORTEST_PATH @source TO @target GRAPH @graph RETURN v._key)
+		         FILTER LENGTH(p) > 0 RETURN p`
+	case "K_SHORTEST_PATHS":
+		query = fmt.Sprintf(`FOR p IN ANY K_SHORTEST_PATHS @source TO @target GRAPH @graph LIMIT %d
+		                     RETURN p.vertices[*]._key`, k)
+	case "K_PATHS":
+		if minDepth < 0 || maxDepth < minDepth {
+			return fmt.Errorf("invalid depth range: %d..%d", minDepth, maxDepth)
+		}
+		query = fmt.Sprintf(`FOR p IN %d..%d ANY K_PATHS @source TO @target GRAPH @graph
+		                     RETURN p.vertices[*]._key`, minDepth, maxDepth)
+	default:
+		return fmt.Errorf("invalid path query: %s", queryType)
+	}
+
+	db, err := getDatabase(cmd)
+	if err != nil {
+		return err
+	}
+
+	r := runner.New(runner.Config{
+		Name:        "runPathTest",
+		Parallelism: parallelism,
+		FirstID:     1,
+		Operation:   "queries",
+		ReportEvery: 1000,
+		Budget:      getErrorBudget(cmd),
+	})
+	startTime := time.Now()
+	limit := time.Duration(runTimeSeconds) * time.Second
+	_, err = r.Run(func(ctx context.Context, w *runner.Worker) error {
+		for time.Since(startTime) < limit && ctx.Err() == nil {
+			if err := runPathQuery(ctx, w, db, &g, queryType, query, k, minDepth, maxDepth); err != nil {
+				if err := w.Fail(err); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// pickSourceAndTarget returns the keys of a random source and target vertex
+// in the same tenant or part and the length of the only path between them,
+// which is -1 if it is not known.
+func (g *pathGraph) pickSourceAndTarget(w *runner.Worker) (string, string, int) {
+	if g.graphType == "tenants" {
+		// Each tenant consists of paths K -> L -> M, choose two different
+		// vertices of one of them:
+		tenantId := "ten" + strconv.Itoa(g.firstTenant+w.Rand.Intn(g.lastTenant+1-g.firstTenant))
+		n := strconv.Itoa(w.Rand.Intn(g.nrPathsPerTenant) + 1)
+		from := w.Rand.Intn(3)
+		to := (from + 1 + w.Rand.Intn(2)) % 3
+		length := from - to
+		if length < 0 {
+			length = -length
+		}
+		return tenantId + ":" + string("KLM"[from]) + n, tenantId + ":" + string("KLM"[to]) + n, length
+	}
+
+	// In a part the edges only connect vertices whose numbers have the same
+	// number of trailing zeros, choose the target from the same class in
+	// half of the cases. The target is never the source, a path to itself
+	// would have a single vertex:
+	partId := strconv.Itoa(w.Rand.Intn(g.numberOfParts) + 1)
+	i := w.Rand.Uint64()%g.nrVertices + 1
+	tmp := uint64(1) << uint(bits.TrailingZeros64(i))
+	count := (g.nrVertices/tmp + 1) / 2 // size of the class of i
+	j := i
+	for j == i && g.nrVertices > 1 {
+		if count > 1 && w.Rand.Intn(2) == 0 {
+			j = tmp * (2*(w.Rand.Uint64()%count) + 1)
+		} else {
+			j = w.Rand.Uint64()%g.nrVertices + 1
+		}
+	}
+	return partId + ":K" + strconv.FormatUint(i, 10), partId + ":K" + strconv.FormatUint(j, 10), -1
+}
+
+// trailingZerosOfKey returns the class of a vertex `<part>:K<n>` of a part.
+func trailingZerosOfKey(key string) int {
+	pos := strings.LastIndex(key, ":K")
+	if pos < 0 {
+		return -1
+	}
+	n, err := strconv.ParseUint(key[pos+2:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return bits.TrailingZeros64(n)
+}
+
+// runPathQuery runs one path query between random vertices and validates
+// the paths it returns.
+func runPathQuery(ctx context.Context, w *runner.Worker, db driver.Database, g *pathGraph,
+	queryType string, query string, k int, minDepth int, maxDepth int) error {
+	start := time.Now()
+	source, target, length := g.pickSourceAndTarget(w)
+	bindVars := map[string]interface{}{
+		"source": g.vertexCollection + "/" + source,
+		"target": g.vertexCollection + "/" + target,
+		"graph":  g.graphName,
+	}
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		w.Printf("Error running query: %v\n", err)
+		return err
+	}
+	defer cursor.Close()
+	paths := make([][]string, 0, k)
+	for cursor.HasMore() {
+		var path []string
+		if _, err := cursor.ReadDocument(ctx, &path); err != nil {
+			w.Printf("Error reading path from cursor: %v\n", err)
+			return err
+		}
+		paths = append(paths, path)
+	}
+
+	if err := validatePaths(g, paths, source, target, length, queryType, k, minDepth, maxDepth); err != nil {
+		w.Printf("Wrong result from %s to %s: %v\n", source, target, err)
+		return errors.Wrapf(runner.ErrWrongResult, "%s from %s to %s: %v", queryType, source, target, err)
+	}
+	if len(paths) == 0 {
+		w.RecordAs("without path", start, 1)
+	} else {
+		w.RecordAs("with path", start, 1)
+	}
+	return nil
+}
+
+// validatePaths checks the paths found between `source` and `target`
+// against the way the graph was generated.
+func validatePaths(g *pathGraph, paths [][]string, source string, target string, length int,
+	queryType string, k int, minDepth int, maxDepth int) error {
+	for i, path := range paths {
+		if len(path) < 2 || path[0] != source || path[len(path)-1] != target {
+			return fmt.Errorf("path %v does not lead from %s to %s", path, source, target)
+		}
+		if queryType == "K_PATHS" && (len(path)-1 < minDepth || len(path)-1 > maxDepth) {
+			return fmt.Errorf("path %v is not between %d and %d long", path, minDepth, maxDepth)
+		}
+		if queryType == "K_SHORTEST_PATHS" && i > 0 && len(path) < len(paths[i-1]) {
+			return fmt.Errorf("path %v is shorter than the one before", path)
+		}
+	}
+	if queryType == "K_SHORTEST_PATHS" && len(paths) > k {
+		return fmt.Errorf("got %d paths instead of at most %d", len(paths), k)
+	}
+	if queryType != "K_SHORTEST_PATHS" && len(paths) > 1 && g.graphType == "tenants" {
+		return fmt.Errorf("got %d paths instead of one", len(paths))
+	}
+
+	if g.graphType == "tenants" {
+		expected := 1
+		if queryType == "K_PATHS" && (length < minDepth || length > maxDepth) {
+			expected = 0
+		}
+		if len(paths) != expected {
+			return fmt.Errorf("got %d paths instead of %d", len(paths), expected)
+		}
+		if expected == 1 && len(paths[0])-1 != length {
+			return fmt.Errorf("path %v is not %d long", paths[0], length)
+		}
+		return nil
+	}
+
+	class := trailingZerosOfKey(source)
+	if class != trailingZerosOfKey(target) && len(paths) > 0 {
+		return fmt.Errorf("found %d paths between different components", len(paths))
+	}
+	// A component holds the vertices of one number of trailing zeros, i.e.
+	// the odd multiples of 2^class up to nrVertices, plus at most the one
+	// beyond the last vertex which create smartgraph writes edges to. A
+	// shortest path visits each of them at most once, so it has at most
+	// as many edges as there are vertices of the class in the part:
+	maxLength := int((g.nrVertices>>uint(class) + 1) / 2)
+	for _, path := range paths {
+		if queryType != "K_PATHS" && len(path)-1 > maxLength {
+			return fmt.Errorf("path %v is longer than the %d vertices of the component", path, maxLength)
+		}
+		for _, key := range path {
+			// create smartgraph writes some edges to vertices beyond the
+			// last one of a part, these show up as null in the path:
+			if key != "" && trailingZerosOfKey(key) != class {
+				return fmt.Errorf("path %v leaves the component of %s", path, source)
+			}
+		}
+	}
+	return nil
+}
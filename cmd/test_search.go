@@ -0,0 +1,345 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	cmdTestSearch = &cobra.Command{
+		Use:   "search",
+		Short: "Run arangosearch queries on the words of the batchimport collection",
+		RunE:  testSearch,
+	}
+)
+
+// searchQueries are the AQL queries of test search by kind. @text is a
+// random entry of wordList.
+var searchQueries = map[string]string{
+	"phrase": `FOR d IN @@view SEARCH PHRASE(d.words, @text, @analyzer) LIMIT @limit RETURN 0`,
+	"tokens": `FOR d IN @@view SEARCH ANALYZER(d.words IN TOKENS(@text, @analyzer), @analyzer) LIMIT @limit RETURN 0`,
+	"bm25": `FOR d IN @@view SEARCH ANALYZER(d.words IN TOKENS(@text, @analyzer), @analyzer)
+	         SORT BM25(d) DESC LIMIT @limit RETURN BM25(d)`,
+}
+
+// searchLag collects how far the view is behind the collection.
+type searchLag struct {
+	mutex    sync.Mutex
+	docLags  []int64
+	timeLags *runner.Stats
+}
+
+func init() {
+	var parallelism int = 4
+	var runTimeSeconds int = 30
+	var collectionName string = "batchimport"
+	var viewName string = ""
+	var queries string = "phrase,tokens,bm25"
+	var limit int = 10
+	var withWords int = 5
+	var lagInterval int64 = 1000
+	var validate bool = true
+	var syncTimeout int = 60
+	cmdTest.AddCommand(cmdTestSearch)
+	cmdTestSearch.Flags().IntVar(&parallelism, "parallelism", parallelism, "set -parallelism to use multiple go routines")
+	cmdTestSearch.Flags().IntVar(&runTimeSeconds, "runTime", runTimeSeconds, "Run time in seconds")
+	cmdTestSearch.Flags().StringVar(&collectionName, "collection", collectionName, "Name of batch import collection.")
+	cmdTestSearch.Flags().StringVar(&viewName, "view", viewName, "Name of arangosearch view, default is the collection name with suffix `_view`")
+	cmdTestSearch.Flags().StringVar(&queries, "queries", queries, "Comma separated list of queries to run: phrase, tokens and bm25")
+	cmdTestSearch.Flags().IntVar(&limit, "limit", limit, "Maximal number of results of a query")
+	cmdTestSearch.Flags().IntVar(&withWords, "with-words", withWords, "Number of words per document used by write batchimport, for the validation")
+	cmdTestSearch.Flags().Int64Var(&lagInterval, "lag-interval", lagInterval, "Milliseconds between two measurements of the indexing lag")
+	cmdTestSearch.Flags().BoolVar(&validate, "validate", validate, "Compare the number of matches of each word with the expected frequency at the end")
+	cmdTestSearch.Flags().IntVar(&syncTimeout, "sync-timeout", syncTimeout, "Seconds to wait for the view to catch up before the validation")
+	errorBudgetFlags(cmdTestSearch)
+	databaseFlag(cmdTestSearch)
+}
+
+// testSearch runs random search queries in parallel while it measures the
+// indexing lag of the view, and validates the number of matches at the end.
+func testSearch(cmd *cobra.Command, _ []string) error {
+	parallelism, _ := cmd.Flags().GetInt("parallelism")
+	runTimeSeconds, _ := cmd.Flags().GetInt("runTime")
+	collectionName, _ := cmd.Flags().GetString("collection")
+	viewName, _ := cmd.Flags().GetString("view")
+	queries, _ := cmd.Flags().GetString("queries")
+	limit, _ := cmd.Flags().GetInt("limit")
+	withWords, _ := cmd.Flags().GetInt("with-words")
+	lagInterval, _ := cmd.Flags().GetInt64("lag-interval")
+	validate, _ := cmd.Flags().GetBool("validate")
+	syncTimeout, _ := cmd.Flags().GetInt("sync-timeout")
+	viewName = searchViewName(collectionName, viewName)
+
+	kinds := strings.Split(queries, ",")
+	for _, kind := range kinds {
+		if _, ok := searchQueries[kind]; !ok {
+			return fmt.Errorf("invalid search query: %s", kind)
+		}
+	}
+	if lagInterval < 1 {
+		return fmt.Errorf("lag interval must be positive: %d", lagInterval)
+	}
+
+	db, err := getDatabase(cmd)
+	if err != nil {
+		return err
+	}
+	coll, err := db.Collection(nil, collectionName)
+	if err != nil {
+		return errors.Wrapf(err, "can not open collection: %s", collectionName)
+	}
+
+	// Measure the lag in the background while the queries run:
+	lag := &searchLag{timeLags: runner.NewStats()}
+	lagCtx, stopLag := context.WithCancel(context.Background())
+	lagDone := make(chan struct{})
+	go func() {
+		defer close(lagDone)
+		lag.measure(lagCtx, db, coll, viewName, time.Duration(lagInterval)*time.Millisecond)
+	}()
+
+	r := runner.New(runner.Config{
+		Name:        "testSearch",
+		Parallelism: parallelism,
+		FirstID:     1,
+		Operation:   "queries",
+		Items:       "docs",
+		ReportEvery: 1000,
+		Budget:      getErrorBudget(cmd),
+	})
+	startTime := time.Now()
+	runTime := time.Duration(runTimeSeconds) * time.Second
+	_, err = r.Run(func(ctx context.Context, w *runner.Worker) error {
+		for time.Since(startTime) < runTime && ctx.Err() == nil {
+			kind := kinds[w.Rand.Intn(len(kinds))]
+			if err := runSearchQuery(ctx, w, db, viewName, kind, limit); err != nil {
+				if err := w.Fail(err); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	stopLag()
+	<-lagDone
+	lag.print()
+	if err != nil {
+		return errors.Wrapf(err, "can not run search queries")
+	}
+
+	if validate && withWords > 0 {
+		return validateWordFrequencies(db, coll, viewName, withWords, time.Duration(syncTimeout)*time.Second)
+	}
+	return nil
+}
+
+// runSearchQuery runs one search query of the given kind for a random
+// entry of wordList.
+func runSearchQuery(ctx context.Context, w *runner.Worker, db driver.Database, viewName string, kind string, limit int) error {
+	start := time.Now()
+	text := wordList[w.Rand.Intn(len(wordList))]
+	cursor, err := db.Query(ctx, searchQueries[kind], map[string]interface{}{
+		"@view":    viewName,
+		"text":     text,
+		"analyzer": searchAnalyzer,
+		"limit":    limit,
+	})
+	if err != nil {
+		w.Printf("Error running %s query: %v\n", kind, err)
+		return err
+	}
+	defer cursor.Close()
+	var count int64
+	previous := math.Inf(1)
+	for cursor.HasMore() {
+		var score float64
+		if _, err := cursor.ReadDocument(ctx, &score); err != nil {
+			w.Printf("Error reading result of %s query: %v\n", kind, err)
+			return err
+		}
+		// Only the bm25 query sorts, the others return 0 as score:
+		if kind == "bm25" && score > previous {
+			w.Printf("Results of %s query for '%s' are not sorted by score\n", kind, text)
+			return errors.Wrapf(runner.ErrWrongResult, "results of %s query for '%s' are not sorted by score", kind, text)
+		}
+		previous = score
+		count++
+	}
+	w.RecordAs(kind, start, count)
+	return nil
+}
+
+// countQuery returns the single number returned by an AQL query.
+func countQuery(ctx context.Context, db driver.Database, query string, bindVars map[string]interface{}) (int64, error) {
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+	var count int64
+	if _, err := cursor.ReadDocument(ctx, &count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// viewCount returns the number of documents with words in the view.
+func viewCount(ctx context.Context, db driver.Database, viewName string) (int64, error) {
+	return countQuery(ctx, db, "FOR d IN @@view SEARCH EXISTS(d.words, 'analyzer', @analyzer) COLLECT WITH COUNT INTO n RETURN n",
+		map[string]interface{}{"@view": viewName, "analyzer": searchAnalyzer})
+}
+
+// wordsCount returns the number of documents with words in the
+// collection, the ones the view can find.
+func wordsCount(ctx context.Context, db driver.Database, coll driver.Collection) (int64, error) {
+	return countQuery(ctx, db, "FOR d IN @@col FILTER d.words != null COLLECT WITH COUNT INTO n RETURN n",
+		map[string]interface{}{"@col": coll.Name()})
+}
+
+// measure compares the number of documents in the view and the ones with
+// words in the collection every `interval` until `ctx` is done. The time lag is the
+// time since the collection had more documents than the view has now.
+func (l *searchLag) measure(ctx context.Context, db driver.Database, coll driver.Collection, viewName string, interval time.Duration) {
+	type sample struct {
+		at    time.Time
+		count int64
+	}
+	var history []sample
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		collCount, err := wordsCount(ctx, db, coll)
+		if err != nil {
+			continue
+		}
+		history = append(history, sample{now, collCount})
+		count, err := viewCount(ctx, db, viewName)
+		if err != nil {
+			continue
+		}
+		var timeLag time.Duration
+		for _, s := range history {
+			if s.count > count {
+				timeLag = now.Sub(s.at)
+				break
+			}
+		}
+		docLag := collCount - count
+		if docLag < 0 {
+			docLag = 0
+		}
+		l.mutex.Lock()
+		l.docLags = append(l.docLags, docLag)
+		l.timeLags.Add(timeLag, 1)
+		l.mutex.Unlock()
+	}
+}
+
+// print prints the distribution of the indexing lag.
+func (l *searchLag) print() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.docLags) == 0 {
+		fmt.Printf("No indexing lag measured.\n")
+		return
+	}
+	sort.Slice(l.docLags, func(a, b int) bool { return l.docLags[a] < l.docLags[b] })
+	var sum int64
+	for _, lag := range l.docLags {
+		sum += lag
+	}
+	fmt.Printf("Indexing lag in documents: %d (median), %d (max), %.1f (average) in %d measurements\n",
+		l.docLags[len(l.docLags)/2], l.docLags[len(l.docLags)-1], float64(sum)/float64(len(l.docLags)), len(l.docLags))
+	fmt.Printf("Indexing lag in time: %s\n", l.timeLags.Latencies())
+}
+
+// validateWordFrequencies waits until the view has caught up with the
+// collection and then compares the number of documents matching each entry
+// of wordList and each of their tokens with the number expected from the
+// way write batchimport chooses `withWords` random entries per document.
+func validateWordFrequencies(db driver.Database, coll driver.Collection, viewName string, withWords int, timeout time.Duration) error {
+	ctx := context.Background()
+	deadline := time.Now().Add(timeout)
+	var total int64
+	for {
+		collCount, err := wordsCount(ctx, db, coll)
+		if err != nil {
+			return errors.Wrapf(err, "can not count documents")
+		}
+		if total, err = viewCount(ctx, db, viewName); err != nil {
+			return errors.Wrapf(err, "can not count documents in view")
+		}
+		if total >= collCount {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("view %s has only %d of %d documents after %v", viewName, total, collCount, timeout)
+		}
+		time.Sleep(time.Second)
+	}
+
+	// Count in how many entries of wordList each token occurs:
+	entries := make(map[string]int)
+	isEntry := make(map[string]bool)
+	for _, entry := range wordList {
+		isEntry[entry] = true
+		seen := make(map[string]bool)
+		for _, token := range strings.Fields(entry) {
+			if !seen[token] {
+				entries[token]++
+				seen[token] = true
+			}
+		}
+	}
+	texts := append([]string{}, wordList...)
+	for token := range entries {
+		if !isEntry[token] {
+			texts = append(texts, token)
+		}
+	}
+	sort.Strings(texts[len(wordList):])
+
+	failed := 0
+	for _, text := range texts {
+		query := "FOR d IN @@view SEARCH PHRASE(d.words, @text, @analyzer) COLLECT WITH COUNT INTO n RETURN n"
+		k := 1
+		if !isEntry[text] {
+			k = entries[text]
+		}
+		count, err := countQuery(ctx, db, query, map[string]interface{}{
+			"@view": viewName, "text": text, "analyzer": searchAnalyzer})
+		if err != nil {
+			return errors.Wrapf(err, "can not count documents with '%s'", text)
+		}
+		// A document contains the text unless none of its words is one of
+		// the k entries which contain it:
+		p := 1 - math.Pow(1-float64(k)/float64(len(wordList)), float64(withWords))
+		expected := float64(total) * p
+		tolerance := 5*math.Sqrt(float64(total)*p*(1-p)) + 1
+		ok := "ok"
+		if math.Abs(float64(count)-expected) > tolerance {
+			ok = "WRONG"
+			failed++
+		}
+		fmt.Printf("'%s': %d documents, expected %.0f +- %.0f, %s\n", text, count, expected, tolerance, ok)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d words have an unexpected number of matches in view %s", failed, viewName)
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/database"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	cmdTestStaleness = &cobra.Command{
+		Use:   "staleness",
+		Short: "Measure how stale reads from followers (allow-dirty-reads) are",
+		RunE:  testStaleness,
+	}
+)
+
+// VersionedDoc is written by test staleness with increasing versions.
+type VersionedDoc struct {
+	Key       string `json:"_key"`
+	Version   int64  `json:"version"`
+	WrittenAt int64  `json:"writtenAt"` // unix time in nanoseconds
+}
+
+// stalenessKey is the state of one key of test staleness.
+type stalenessKey struct {
+	key   string
+	shard string
+	mutex sync.Mutex
+	acked []time.Time // acked[v-1] is the time at which version v was acknowledged
+}
+
+// latest returns the last acknowledged version.
+func (k *stalenessKey) latest() int64 {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return int64(len(k.acked))
+}
+
+// ackedAt returns the time at which `version` was acknowledged.
+func (k *stalenessKey) ackedAt(version int64) time.Time {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.acked[version-1]
+}
+
+// staleness collects the version and time lags of the reads of one shard.
+type staleness struct {
+	versionLags []int64
+	timeLags    *runner.Stats
+	dirty       int64 // number of reads which the server marked as potentially dirty
+}
+
+func newStaleness() *staleness {
+	return &staleness{timeLags: runner.NewStats()}
+}
+
+func (s *staleness) merge(o *staleness) {
+	s.versionLags = append(s.versionLags, o.versionLags...)
+	s.timeLags.Merge(o.timeLags)
+	s.dirty += o.dirty
+}
+
+// versionLag returns the version lag below which the fraction `p` of all
+// reads lies.
+func (s *staleness) versionLag(p float64) int64 {
+	i := int(p * float64(len(s.versionLags)))
+	if i >= len(s.versionLags) {
+		i = len(s.versionLags) - 1
+	}
+	return s.versionLags[i]
+}
+
+func init() {
+	var writers int = 1
+	var readers int = 4
+	var runTimeSeconds int = 30
+	var nrKeys int = 100
+	var collectionName string = "staleness"
+	var replicationFactor int = 2
+	var numberOfShards int = 3
+	cmdTest.AddCommand(cmdTestStaleness)
+	cmdTestStaleness.Flags().IntVar(&writers, "writers", writers, "Number of go routines writing new versions")
+	cmdTestStaleness.Flags().IntVar(&readers, "readers", readers, "Number of go routines reading with allow-dirty-reads")
+	cmdTestStaleness.Flags().IntVar(&runTimeSeconds, "runTime", runTimeSeconds, "Run time in seconds")
+	cmdTestStaleness.Flags().IntVar(&nrKeys, "keys", nrKeys, "Number of keys which get new versions")
+	cmdTestStaleness.Flags().StringVar(&collectionName, "collection", collectionName, "Name of collection, which is created if it does not exist")
+	cmdTestStaleness.Flags().IntVar(&replicationFactor, "replicationFactor", replicationFactor, "replication factor of a new collection")
+	cmdTestStaleness.Flags().IntVar(&numberOfShards, "numberOfShards", numberOfShards, "number of shards of a new collection")
+	errorBudgetFlags(cmdTestStaleness)
+	databaseFlag(cmdTestStaleness)
+}
+
+// testStaleness writes increasing versions of some keys and concurrently
+// reads them with allow-dirty-reads.
+func testStaleness(cmd *cobra.Command, _ []string) error {
+	writers, _ := cmd.Flags().GetInt("writers")
+	readers, _ := cmd.Flags().GetInt("readers")
+	runTimeSeconds, _ := cmd.Flags().GetInt("runTime")
+	nrKeys, _ := cmd.Flags().GetInt("keys")
+	collectionName, _ := cmd.Flags().GetString("collection")
+	replicationFactor, _ := cmd.Flags().GetInt("replicationFactor")
+	numberOfShards, _ := cmd.Flags().GetInt("numberOfShards")
+	if writers < 1 || readers < 1 || nrKeys < writers {
+		return fmt.Errorf("need at least one writer, one reader and as many keys as writers")
+	}
+
+	db, err := getDatabase(cmd)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	coll, err := database.CreateOrGetCollection(ctx, db, collectionName, &driver.CreateCollectionOptions{
+		NumberOfShards:    numberOfShards,
+		ReplicationFactor: replicationFactor,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "can not create/get collection: %s", collectionName)
+	}
+
+	// Write version 1 of all keys, so that every read finds a document:
+	keys := make([]*stalenessKey, nrKeys)
+	for i := range keys {
+		k := &stalenessKey{key: "stale" + strconv.Itoa(i)}
+		if k.shard, err = responsibleShard(ctx, db, collectionName, k.key); err != nil {
+			return errors.Wrapf(err, "can not find shard of key %s", k.key)
+		}
+		if err := writeVersion(ctx, coll, k, 1); err != nil {
+			return errors.Wrapf(err, "can not write key %s", k.key)
+		}
+		keys[i] = k
+	}
+
+	var mutex sync.Mutex
+	shards := make(map[string]*staleness)
+	r := runner.New(runner.Config{
+		Name:        "testStaleness",
+		Parallelism: writers + readers,
+		Operation:   "operations",
+		Budget:      getErrorBudget(cmd),
+	})
+	startTime := time.Now()
+	limit := time.Duration(runTimeSeconds) * time.Second
+	_, err = r.Run(func(ctx context.Context, w *runner.Worker) error {
+		if w.ID < writers {
+			return writeVersions(ctx, w, coll, keys, writers, startTime, limit)
+		}
+		local := make(map[string]*staleness)
+		err := readVersions(ctx, w, coll, keys, startTime, limit, local)
+		mutex.Lock()
+		defer mutex.Unlock()
+		for shard, s := range local {
+			if shards[shard] == nil {
+				shards[shard] = newStaleness()
+			}
+			shards[shard].merge(s)
+		}
+		return err
+	})
+	printStaleness(ctx, coll, shards)
+	if err != nil {
+		return errors.Wrapf(err, "can not measure staleness")
+	}
+	return nil
+}
+
+// responsibleShard asks the server which shard `key` is stored in. On a
+// single server there are no shards and "" is returned.
+func responsibleShard(ctx context.Context, db driver.Database, collectionName string, key string) (string, error) {
+	conn := _client.Connection()
+	req, err := conn.NewRequest("PUT", "_db/"+url.PathEscape(db.Name())+"/_api/collection/"+
+		url.PathEscape(collectionName)+"/responsibleShard")
+	if err != nil {
+		return "", err
+	}
+	if _, err := req.SetBody(map[string]string{"_key": key}); err != nil {
+		return "", err
+	}
+	resp, err := conn.Do(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode() == 501 {
+		return "", nil // not implemented on a single server
+	}
+	if err := resp.CheckStatus(200); err != nil {
+		return "", err
+	}
+	var result struct {
+		ShardID string `json:"shardId"`
+	}
+	if err := resp.ParseBody("", &result); err != nil {
+		return "", err
+	}
+	return result.ShardID, nil
+}
+
+// writeVersion writes `version` of the key and records when it was
+// acknowledged.
+func writeVersion(ctx context.Context, coll driver.Collection, k *stalenessKey, version int64) error {
+	ctx2, cancel := context.WithTimeout(driver.WithOverwriteMode(ctx, driver.OverwriteModeReplace), time.Hour)
+	defer cancel()
+	_, err := coll.CreateDocument(ctx2, &VersionedDoc{Key: k.key, Version: version, WrittenAt: time.Now().UnixNano()})
+	if err != nil {
+		return err
+	}
+	k.mutex.Lock()
+	k.acked = append(k.acked, time.Now())
+	k.mutex.Unlock()
+	return nil
+}
+
+// writeVersions writes new versions of the keys owned by this writer, which
+// are the ones whose index is the writer id modulo the number of writers.
+func writeVersions(ctx context.Context, w *runner.Worker, coll driver.Collection, keys []*stalenessKey,
+	writers int, startTime time.Time, limit time.Duration) error {
+	for i := w.ID; time.Since(startTime) < limit && ctx.Err() == nil; i += writers {
+		if i >= len(keys) {
+			i = w.ID
+		}
+		k := keys[i]
+		start := time.Now()
+		if err := writeVersion(ctx, coll, k, k.latest()+1); err != nil {
+			w.Printf("testStaleness: could not write key %s: %v\n", k.key, err)
+			if err := w.Fail(err); err != nil {
+				return err
+			}
+			continue
+		}
+		w.RecordAs("write", start, 1)
+	}
+	return nil
+}
+
+// readVersions reads random keys with allow-dirty-reads and records per
+// shard how many versions behind and for how long stale the result was.
+func readVersions(ctx context.Context, w *runner.Worker, coll driver.Collection, keys []*stalenessKey,
+	startTime time.Time, limit time.Duration, shards map[string]*staleness) error {
+	for time.Since(startTime) < limit && ctx.Err() == nil {
+		k := keys[w.Rand.Intn(len(keys))]
+		latest := k.latest()
+		start := time.Now()
+		var wasDirty bool
+		var doc VersionedDoc
+		ctx2, cancel := context.WithTimeout(driver.WithAllowDirtyReads(ctx, &wasDirty), time.Hour)
+		_, err := coll.ReadDocument(ctx2, k.key, &doc)
+		cancel()
+		if err != nil {
+			w.Printf("testStaleness: could not read key %s: %v\n", k.key, err)
+			if err := w.Fail(err); err != nil {
+				return err
+			}
+			continue
+		}
+		s := shards[k.shard]
+		if s == nil {
+			s = newStaleness()
+			shards[k.shard] = s
+		}
+		// A version newer than the latest acknowledged one is not stale,
+		// otherwise the result has been stale since the next version was
+		// acknowledged:
+		var versionLag int64
+		var timeLag time.Duration
+		if doc.Version < latest {
+			versionLag = latest - doc.Version
+			timeLag = start.Sub(k.ackedAt(doc.Version + 1))
+		}
+		s.versionLags = append(s.versionLags, versionLag)
+		s.timeLags.Add(timeLag, 1)
+		if wasDirty {
+			s.dirty++
+			w.RecordAs("dirty read", start, 1)
+		} else {
+			w.RecordAs("read", start, 1)
+		}
+	}
+	return nil
+}
+
+// printStaleness prints the staleness distribution of each shard with its
+// servers. ArangoDB does not tell which replica answered a read, so with
+// replication factor 2 the shard stands for its only follower.
+func printStaleness(ctx context.Context, coll driver.Collection, shards map[string]*staleness) {
+	servers := make(map[driver.ShardID][]driver.ServerID)
+	if cs, err := coll.Shards(ctx, true); err == nil {
+		servers = cs.Shards
+	}
+	names := make([]string, 0, len(shards))
+	for shard := range shards {
+		names = append(names, shard)
+	}
+	sort.Strings(names)
+	var total int64
+	for _, shard := range names {
+		s := shards[shard]
+		if len(s.versionLags) == 0 {
+			continue
+		}
+		sort.Slice(s.versionLags, func(a, b int) bool { return s.versionLags[a] < s.versionLags[b] })
+		var stale int64
+		for _, lag := range s.versionLags {
+			if lag > 0 {
+				stale++
+			}
+		}
+		name := shard
+		if name == "" {
+			name = "single server"
+		}
+		if replicas := servers[driver.ShardID(shard)]; len(replicas) > 0 {
+			name = fmt.Sprintf("%s (leader %s, followers %v)", shard, replicas[0], replicas[1:])
+		}
+		fmt.Printf("Shard %s: %d reads, %d potentially dirty, %d stale (%.2f%%)\n",
+			name, len(s.versionLags), s.dirty, stale, 100*float64(stale)/float64(len(s.versionLags)))
+		fmt.Printf("  version lag: %d (median), %d (90%%ile), %d (99%%ile), %d (max)\n",
+			s.versionLag(0.5), s.versionLag(0.9), s.versionLag(0.99), s.versionLags[len(s.versionLags)-1])
+		fmt.Printf("  time lag: %s\n", s.timeLags.Latencies())
+		total += stale
+	}
+	fmt.Printf("Stale reads in total: %d\n", total)
+}
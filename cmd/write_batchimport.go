@@ -5,11 +5,12 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"math"
 	"math/rand"
-	"sort"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -43,27 +44,32 @@ var (
 
 type Point []float64
 
+// Poly is a GeoJSON polygon with a single ring.
 type Poly struct {
 	Type          string `json:"type"`
-	Coordinates   []Point `json:"coordinates"`
+	Coordinates   [][]Point `json:"coordinates"`
 }
 
 type Doc struct {
 	Key           string `json:"_key"`
 	Sha           string `json:"sha"`
 	Payload       string `json:"payload"`
-	Geo           *Poly  `Json:"geo,omitempty"`
+	Geo           *Poly  `json:"geo,omitempty"`
 	Words         string `json:"words,omitempty"`
 }
 
-// makeRandomPolygon makes a random GeoJson polygon.
+// makeSquare makes a GeoJSON polygon which is a square with side length
+// `size` degrees and its south west corner at `lon`, `lat`.
+func makeSquare(lon float64, lat float64, size float64) *Poly {
+	return &Poly{Type: "Polygon", Coordinates: [][]Point{{
+		{lon, lat}, {lon + size, lat}, {lon + size, lat + size}, {lon, lat + size}, {lon, lat},
+	}}}
+}
+
+// makeRandomPolygon makes a random GeoJson polygon, a square with a side
+// length of up to 1 degree.
 func makeRandomPolygon(source *rand.Rand) *Poly {
-	ret := Poly{ Type: "polygon", Coordinates: make([]Point, 0, 5) }
-	for i := 1; i <= 4 ; i += 1 {
-		ret.Coordinates = append(ret.Coordinates,
-			Point{ source.Float64() * 300.0 - 90.0, source.Float64() * 160.0 - 80.0})
-	}
-	return &ret
+	return makeSquare(source.Float64()*359.0-180.0, source.Float64()*159.0-80.0, 0.01+source.Float64()*0.99)
 }
 
 // makeRandomStringWithSpaces creates slice of bytes for the provided length.
@@ -99,6 +105,19 @@ func makeRandomWords(nr int, source *rand.Rand) string {
 	return string(b)
 }
 
+// batchImportKey returns the key of document number `which` written by
+// write batchimport, which is a prefix of `keySize` hex digits of a sha256.
+func batchImportKey(which int64, keySize int) string {
+	key := fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%d", which))))
+	return key[0:keySize]
+}
+
+// batchImportSha returns the `sha` attribute of document number `which`
+// written by write batchimport.
+func batchImportSha(which int64) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte("SHA"+fmt.Sprintf("%d", which))))
+}
+
 func init() {
 	var parallelism int = 1
 	var startDelay int64 = 5
@@ -118,6 +137,9 @@ func init() {
 	cmdWriteBatchImport.Flags().BoolVar(&withGeo, "with-geo", withGeo, "Add some geo data to `geo` attribute.")
 	cmdWriteBatchImport.Flags().IntVar(&withWords, "with-words", withWords, "Add so many words to `words` attribute.")
 	cmdWriteBatchImport.Flags().IntVar(&keySize, "key-size", keySize, "Take a prefix of that many bytes from the sha256 as key.")
+	overwriteModeFlag(cmdWriteBatchImport, driver.OverwriteModeReplace)
+	errorBudgetFlags(cmdWriteBatchImport)
+	databaseFlag(cmdWriteBatchImport)
 }
 
 // writeBatchImport writes edges in parallel
@@ -134,77 +156,113 @@ func writeBatchImport(cmd *cobra.Command, _ []string) error {
 	if keySize < 1 || keySize > 64 {
 		keySize = 64
   }
+	overwriteMode, err := getOverwriteMode(cmd)
+	if err != nil {
+		return err
+	}
 
-	db, err := _client.Database(context.Background(), "_system")
+	db, err := getDatabase(cmd)
 	if err != nil {
-		return errors.Wrapf(err, "can not get database: %s", "_system")
+		return err
 	}
 
-	if err := writeSomeBatchesParallel(parallelism, number, startDelay, payloadSize, batchSize, collectionName, withGeo, withWords, keySize, db); err != nil {
+	ctx := context.Background()
+	coll, err := db.Collection(ctx, collectionName)
+	if err != nil {
+		return errors.Wrapf(err, "can not open collection: %s", collectionName)
+	}
+	before, err := countBatchImportDocuments(ctx, coll)
+	if err != nil {
+		return errors.Wrapf(err, "can not count documents")
+	}
+
+	written, replaced, err := writeSomeBatchesParallel(parallelism, number, startDelay, payloadSize, batchSize, collectionName, withGeo, withWords, keySize, overwriteMode, getErrorBudget(cmd), db)
+	if err != nil {
 		return errors.Wrapf(err, "can not do some batch imports")
 	}
 
+	// Truncated keys can collide, in which case a later document replaces
+	// an earlier one. Compare the prediction with what has happened:
+	after, err := countBatchImportDocuments(ctx, coll)
+	if err != nil {
+		return errors.Wrapf(err, "can not count documents")
+	}
+	switch overwriteMode {
+	case driver.OverwriteModeConflict:
+		fmt.Printf("Wrote %d documents with key size %d, %d of them were not written since the key existed.\n", written, keySize, replaced)
+	case driver.OverwriteModeIgnore:
+		fmt.Printf("Wrote %d documents with key size %d, those whose key existed were ignored.\n", written, keySize)
+	default:
+		fmt.Printf("Wrote %d documents with key size %d, %d of them replaced an existing document.\n", written, keySize, replaced)
+	}
+	if keySize < 64 {
+		fmt.Printf("Expected number of key collisions among %d documents with key size %d: %.1f\n",
+			written, keySize, expectedKeyCollisions(written, keySize))
+	}
+	fmt.Printf("Collection has %d distinct documents, %d more than before.\n", after, after-before)
+
+	// Let read batchimport know which documents there are, unless some
+	// batches failed and left gaps in the key space:
+	if planned := int64(parallelism) * number * batchSize; written < planned {
+		fmt.Printf("Only %d of %d documents were written, not updating the metadata document.\n", written, planned)
+		return nil
+	}
+	if err := writeBatchImportMetadata(ctx, coll, int64(parallelism)*number*batchSize, keySize); err != nil {
+		return errors.Wrapf(err, "can not write metadata document")
+	}
+
 	return nil
 }
 
-// writeSomeBatchesParallel does some batch imports in parallel
-func writeSomeBatchesParallel(parallelism int, number int64, startDelay int64, payloadSize int64, batchSize int64, collectionName string, withGeo bool, withWords int, keySize int, db driver.Database) error {
-	var mutex sync.Mutex
-	totaltimestart := time.Now()
-	wg := sync.WaitGroup{}
-	haveError := false
-	for i := 0; i <= parallelism - 1; i++ {
-	  time.Sleep(time.Duration(startDelay) * time.Millisecond)
-		i := i // bring into scope
-		wg.Add(1)
-
-		go func(wg *sync.WaitGroup, i int) {
-			defer wg.Done()
-			fmt.Printf("Starting go routine...\n")
-			err := writeSomeBatches(number, int64(i), payloadSize, batchSize, collectionName, withGeo, withWords, keySize, db, &mutex)
-			if err != nil {
-				fmt.Printf("writeSomeBatches error: %v\n", err)
-				haveError = true
-			}
-			mutex.Lock()
-			fmt.Printf("Go routine %d done\n", i)
-			mutex.Unlock()
-		}(&wg, i)
-	}
-
-	wg.Wait()
-	totaltimeend := time.Now()
-	totaltime := totaltimeend.Sub(totaltimestart)
-	batchesPerSec := float64(int64(parallelism) * number) / (float64(totaltime) / float64(time.Second))
-	docspersec := float64(int64(parallelism) * number * batchSize) / (float64(totaltime) / float64(time.Second))
-	fmt.Printf("\nTotal number of documents written: %d, total time: %v, total batches per second: %f, total docs per second: %f\n", int64(parallelism) * number * batchSize, totaltimeend.Sub(totaltimestart), batchesPerSec, docspersec)
-	if !haveError {
-		return nil
+// expectedKeyCollisions returns the expected number of documents which
+// replace another one if `n` documents get random keys of `keySize` hex
+// digits, i.e. `n` minus the expected number of distinct keys.
+func expectedKeyCollisions(n int64, keySize int) float64 {
+	keySpace := math.Pow(16, float64(keySize))
+	distinct := -keySpace * math.Expm1(float64(n)*math.Log1p(-1/keySpace))
+	return float64(n) - distinct
+}
+
+// writeSomeBatchesParallel does some batch imports in parallel and returns
+// the number of documents written and how many of them met an existing
+// key, i.e. replaced or updated a document or failed with a conflict.
+func writeSomeBatchesParallel(parallelism int, number int64, startDelay int64, payloadSize int64, batchSize int64, collectionName string, withGeo bool, withWords int, keySize int, overwriteMode driver.OverwriteMode, budget runner.ErrorBudget, db driver.Database) (int64, int64, error) {
+	r := runner.New(runner.Config{
+		Name:        "writeSomeBatches",
+		Parallelism: parallelism,
+		StartDelay:  time.Duration(startDelay) * time.Millisecond,
+		Operation:   "batches",
+		Items:       "docs",
+		Budget:      budget,
+	})
+	var replaced int64
+	stats, err := r.Run(func(ctx context.Context, w *runner.Worker) error {
+		return writeSomeBatches(ctx, w, number, payloadSize, batchSize, collectionName, withGeo, withWords, keySize, overwriteMode, &replaced, db)
+	})
+	if err != nil {
+		return 0, 0, err
 	}
-	fmt.Printf("Error in writeSomeBatches.\n")
-	return fmt.Errorf("Error in writeSomeBatches.")
+	return stats.Items, replaced, nil
 }
 
-// writeSomeBatches writes `nrBatches` batches with `batchSize` documents.
-func writeSomeBatches(nrBatches int64, id int64, payloadSize int64, batchSize int64, collectionName string, withGeo bool, withWords int, keySize int, db driver.Database, mutex *sync.Mutex) error {
-	edges, err := db.Collection(nil, collectionName)
+// writeSomeBatches writes `nrBatches` batches with `batchSize` documents
+// and adds the number of documents which met an existing key to `replaced`.
+func writeSomeBatches(ctx context.Context, w *runner.Worker, nrBatches int64, payloadSize int64, batchSize int64, collectionName string, withGeo bool, withWords int, keySize int, overwriteMode driver.OverwriteMode, replaced *int64, db driver.Database) error {
+	edges, err := db.Collection(ctx, collectionName)
 	if err != nil {
-		fmt.Printf("writeSomeBatches: could not open `%s` collection: %v\n", collectionName, err)
+		w.Printf("writeSomeBatches: could not open `%s` collection: %v\n", collectionName, err)
 		return err
 	}
+	id := int64(w.ID)
 	docs := make([]Doc, 0, batchSize)
-  times := make([]time.Duration, 0, batchSize)
-	cyclestart := time.Now()
-	last100start := cyclestart
-	source := rand.New(rand.NewSource(int64(id) + rand.Int63()))
-	for i := int64(1); i <= nrBatches; i++ {
+	last100start := time.Now()
+	source := w.Rand
+	for i := int64(1); i <= nrBatches && ctx.Err() == nil; i++ {
 		start := time.Now()
     for j := int64(1); j <= batchSize; j++ {
 			which := (id * nrBatches + i - 1) * batchSize + j - 1
-			x := fmt.Sprintf("%d", which)
-			key := fmt.Sprintf("%x", sha256.Sum256([]byte(x)))
-			x = "SHA" + x
-			sha := fmt.Sprintf("%x", sha256.Sum256([]byte(x)))
+			key := batchImportKey(which, keySize)
+			sha := batchImportSha(which)
 			pay := makeRandomStringWithSpaces(int(payloadSize), source)
 			var poly *Poly
 			if withGeo {
@@ -215,34 +273,35 @@ func writeSomeBatches(nrBatches int64, id int64, payloadSize int64, batchSize in
 				words = makeRandomWords(withWords, source)
 		  }
 			docs = append(docs, Doc{
-				Key: key[0:keySize], Sha: sha, Payload: pay, Geo: poly, Words: words })
+				Key: key, Sha: sha, Payload: pay, Geo: poly, Words: words})
 	  }
-		ctx, cancel := context.WithTimeout(driver.WithOverwriteMode(context.Background(), driver.OverwriteModeReplace), time.Hour)
-		_, _, err := edges.CreateDocuments(ctx, docs)
+		ctx2, cancel := context.WithTimeout(driver.WithOverwriteMode(ctx, overwriteMode), time.Hour)
+		metas, errs, err := edges.CreateDocuments(ctx2, docs)
 		cancel()
+		docs = docs[0:0]
+		if err == nil {
+			var existing int64
+			existing, err = countDocumentErrors(errs)
+			atomic.AddInt64(replaced, existing)
+		}
 		if err != nil {
-			fmt.Printf("writeSomeBatches: could not write batch: %v\n", err)
-			return err
+			w.Printf("writeSomeBatches: could not write batch: %v\n", err)
+			if err := w.Fail(err); err != nil {
+				return err
+			}
+			continue
 		}
-		docs = docs[0:0]
-    times = append(times, time.Now().Sub(start))
+		for _, meta := range metas {
+			if meta.OldRev != "" {
+				atomic.AddInt64(replaced, 1)
+			}
+		}
+		w.Record(start, batchSize)
 		if i % 100 == 0 {
 			dur := float64(time.Now().Sub(last100start)) / float64(time.Second)
-			mutex.Lock()
-			fmt.Printf("%s Have imported %d batches for id %d, last 100 took %f seconds.\n", time.Now(), int(i), id, dur)
-			mutex.Unlock()
+			w.Printf("%s Have imported %d batches for id %d, last 100 took %f seconds.\n", time.Now(), int(i), id, dur)
+			last100start = time.Now()
 		}
 	}
-	sort.Sort(DurationSlice(times))
-	var sum int64 = 0
-	for _, t := range times {
-		sum = sum + int64(t)
-	}
-	totaltime := time.Now().Sub(cyclestart)
-	nrDocs := batchSize * nrBatches
-	docspersec := float64(nrDocs) / (float64(totaltime) / float64(time.Second))
-	mutex.Lock()
-	fmt.Printf("Times for %d batches (per batch): %s (median), %s (90%%ile), %s (99%%ilie), %s (average), docs per second in this go routine: %f\n", nrBatches, times[int(float64(0.5) * float64(nrBatches))], times[int(float64(0.9) * float64(nrBatches))], times[int(float64(0.99) * float64(nrBatches))], time.Duration(sum / nrBatches), docspersec)
-	mutex.Unlock()
 	return nil
 }
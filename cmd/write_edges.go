@@ -4,12 +4,11 @@ import (
 	"context"
 	"fmt"
 	"github.com/arangodb/go-driver"
+	"github.com/neunhoef/collectionmaker/pkg/runner"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"math/rand"
-	"sort"
 	"strconv"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,6 +21,7 @@ var (
 )
 
 type Edge struct {
+	Key           string `json:"_key,omitempty"`
 	From          string `json:"_from"`
 	To            string `json:"_to"`
 	FromUid       int    `json:"fromUid"`
@@ -30,13 +30,124 @@ type Edge struct {
 	Last_modified string `json:"last_modified"`
 }
 
+// edgeWriter describes how write edges and write elcheapo write edges.
+type edgeWriter struct {
+	collectionName       string
+	vertexCollectionName string
+	keyPrefix            string
+	firstUid             int
+	uids                 int
+	overwriteMode        driver.OverwriteMode
+	writeMode            string // one of edgeWriteModes, only used by write edges
+	seed                 int64  // the edges of go routine `id` are random with seed+id
+	batchSize            int64  // edges per request or per transaction
+	existing             int64  // edges not written because their key exists, accessed atomically
+}
+
 func init() {
 	var parallelism int = 1
 	var startDelay int64 = 5
 	var number int64 = 1000000
+	var collectionName string = "edges"
+	var vertexCollectionName string = "pubmed"
+	var keyPrefix string = "w"
+	var seed int64 = 0
+	var batchSize int64 = 10000
 	cmdWriteEdges.Flags().IntVar(&parallelism, "parallelism", parallelism, "set -parallelism to use multiple go routines")
 	cmdWriteEdges.Flags().Int64Var(&number, "number", number, "set -number for number of edges to write per go routine")
 	cmdWriteEdges.Flags().Int64Var(&startDelay, "start-delay", startDelay, "Delay between the start of two go routines.")
+	cmdWriteEdges.Flags().StringVar(&collectionName, "collection", collectionName, "Name of edge collection.")
+	cmdWriteEdges.Flags().StringVar(&vertexCollectionName, "vertex-collection", vertexCollectionName, "Name of vertex collection the edges point to.")
+	cmdWriteEdges.Flags().StringVar(&keyPrefix, "key-prefix", keyPrefix, "Prefix of the edge keys, which are <prefix><go routine>_<number>")
+	cmdWriteEdges.Flags().Int64Var(&seed, "seed", seed, "Seed of the random edges, the same seed writes the same edges again")
+	cmdWriteEdges.Flags().Int64Var(&batchSize, "batch-size", batchSize, "Number of edges written per request.")
+	overwriteModeFlag(cmdWriteEdges, driver.OverwriteModeIgnore)
+	writeModeFlag(cmdWriteEdges)
+	uidRangeFlags(cmdWriteEdges)
+	createVerticesFlags(cmdWriteEdges)
+	errorBudgetFlags(cmdWriteEdges)
+	databaseFlag(cmdWriteEdges)
+}
+
+// newEdgeWriter reads the flags common to write edges and write elcheapo.
+func newEdgeWriter(cmd *cobra.Command) (*edgeWriter, error) {
+	e := &edgeWriter{}
+	e.collectionName, _ = cmd.Flags().GetString("collection")
+	e.vertexCollectionName, _ = cmd.Flags().GetString("vertex-collection")
+	e.keyPrefix, _ = cmd.Flags().GetString("key-prefix")
+	e.seed, _ = cmd.Flags().GetInt64("seed")
+	var err error
+	if e.firstUid, e.uids, err = getUidRange(cmd); err != nil {
+		return nil, err
+	}
+	if e.overwriteMode, err = getOverwriteMode(cmd); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// edgeKey returns the key of edge number `index` of go routine `id`, so
+// that a restarted run writes the same keys again.
+func (e *edgeWriter) edgeKey(id int, index int64) string {
+	return e.keyPrefix + strconv.Itoa(id) + "_" + strconv.FormatInt(index, 10)
+}
+
+// makeEdge makes edge number `index` of the go routine between two random
+// vertices of the uid range. The random source of the go routine is seeded
+// with the seed of the writer, so a run with the same flags makes the same
+// edges.
+func (e *edgeWriter) makeEdge(w *runner.Worker, index int64) Edge {
+	fromUid := e.firstUid + w.Rand.Intn(e.uids)
+	toUid := e.firstUid + w.Rand.Intn(e.uids)
+	return Edge{
+		Key:           e.edgeKey(w.ID, index),
+		From:          uidVertex(e.vertexCollectionName, fromUid),
+		To:            uidVertex(e.vertexCollectionName, toUid),
+		FromUid:       fromUid,
+		ToUid:         toUid,
+		Score:         w.Rand.Intn(10000000),
+		Last_modified: time.Now().Format(time.RFC3339),
+	}
+}
+
+// insertMode returns the overwrite mode sent to the server. Overwrite mode
+// ignore is sent as conflict, which keeps existing edges in the same way
+// but reports them, so that they can be counted.
+func (e *edgeWriter) insertMode() driver.OverwriteMode {
+	if e.overwriteMode == driver.OverwriteModeIgnore {
+		return driver.OverwriteModeConflict
+	}
+	return e.overwriteMode
+}
+
+// createEdges inserts `eds` with the overwrite mode of the writer and
+// returns the number of edges written. Edges which exist already with
+// overwrite mode conflict or ignore are counted, not failed.
+func (e *edgeWriter) createEdges(ctx context.Context, edges driver.Collection, eds []Edge) (int64, error) {
+	_, errs, err := edges.CreateDocuments(driver.WithOverwriteMode(ctx, e.insertMode()), eds)
+	if err != nil {
+		return 0, err
+	}
+	existing, err := countDocumentErrors(errs)
+	atomic.AddInt64(&e.existing, existing)
+	return int64(len(eds)) - existing, err
+}
+
+// nextBatch returns the number of edges of the batch after `done` of
+// `nrEdges` edges, the last batch has the remainder.
+func (e *edgeWriter) nextBatch(done int64, nrEdges int64) int64 {
+	if nrEdges-done < e.batchSize {
+		return nrEdges - done
+	}
+	return e.batchSize
+}
+
+// printExisting reports the edges which were not written because their
+// key existed.
+func (e *edgeWriter) printExisting() {
+	if e.insertMode() == driver.OverwriteModeConflict {
+		fmt.Printf("%d edges existed already and were not written.\n", atomic.LoadInt64(&e.existing))
+	}
 }
 
 // writeEdges writes edges in parallel
@@ -44,111 +155,91 @@ func writeEdges(cmd *cobra.Command, _ []string) error {
 	parallelism, _ := cmd.Flags().GetInt("parallelism")
 	number, _ := cmd.Flags().GetInt64("number")
 	startDelay, _ := cmd.Flags().GetInt64("start-delay")
+	writer, err := newEdgeWriter(cmd)
+	if err != nil {
+		return err
+	}
+	writer.batchSize, _ = cmd.Flags().GetInt64("batch-size")
+	if writer.batchSize < 1 {
+		return fmt.Errorf("batch size must be positive: %d", writer.batchSize)
+	}
+	if writer.writeMode, err = getWriteMode(cmd, writer.overwriteMode); err != nil {
+		return err
+	}
 
-	db, err := _client.Database(context.Background(), "_system")
+	db, err := getDatabase(cmd)
 	if err != nil {
-		return errors.Wrapf(err, "can not get database: %s", "_system")
+		return err
+	}
+
+	if err := setupVertices(cmd, db, writer.vertexCollectionName, writer.collectionName); err != nil {
+		return errors.Wrapf(err, "can not create vertices")
 	}
 
-	if err := writeSomeEdgesParallel(parallelism, number, startDelay, db); err != nil {
+	err = writeSomeEdgesParallel(parallelism, number, startDelay, writer, getErrorBudget(cmd), db)
+	writer.printExisting()
+	if err != nil {
 		return errors.Wrapf(err, "can not setup some tenants")
 	}
 
 	return nil
 }
 
-// writeSomeEdges creates some edges in parallel
-func writeSomeEdgesParallel(parallelism int, number int64, startDelay int64, db driver.Database) error {
-	var mutex sync.Mutex
-	totaltimestart := time.Now()
-	wg := sync.WaitGroup{}
-	haveError := false
-	for i := 1; i <= parallelism; i++ {
-	  time.Sleep(time.Duration(startDelay) * time.Millisecond)
-		i := i // bring into scope
-		wg.Add(1)
-
-		go func(wg *sync.WaitGroup, i int) {
-			defer wg.Done()
-			fmt.Printf("Starting go routine...\n")
-			id := "id_" + strconv.FormatInt(int64(i), 10)
-			err := writeSomeEdges(number, id, db, &mutex)
-			if err != nil {
-				fmt.Printf("writeSomeEdges error: %v\n", err)
-				haveError = true
-			}
-			mutex.Lock()
-			fmt.Printf("Go routine %d done\n", i)
-			mutex.Unlock()
-		}(&wg, i)
-	}
-
-	wg.Wait()
-	totaltimeend := time.Now()
-	totaltime := totaltimeend.Sub(totaltimestart)
-	docspersec := float64(int64(parallelism) * number) / (float64(totaltime) / float64(time.Second))
-	fmt.Printf("\nTotal number of edges written: %d, total time: %v, total edges per second: %f\n", int64(parallelism) * number, totaltimeend.Sub(totaltimestart), docspersec)
-	if !haveError {
-		return nil
-	}
-	fmt.Printf("Error in writeSomeEdges.\n")
-	return fmt.Errorf("Error in writeSomeEdges.")
+// writeSomeEdgesParallel creates some edges in parallel
+func writeSomeEdgesParallel(parallelism int, number int64, startDelay int64, writer *edgeWriter, budget runner.ErrorBudget, db driver.Database) error {
+	r := runner.New(runner.Config{
+		Name:          "writeSomeEdges",
+		Parallelism:   parallelism,
+		FirstID:       1,
+		StartDelay:    time.Duration(startDelay) * time.Millisecond,
+		Operation:     "batches",
+		Items:         "edges",
+		ReportEvery:   100,
+		Budget:        budget,
+		Deterministic: true,
+		Seed:          writer.seed,
+	})
+	_, err := r.Run(func(ctx context.Context, w *runner.Worker) error {
+		return writeSomeEdges(ctx, w, number, writer, db)
+	})
+	return err
 }
 
-// writeOneTenant writes `nrPaths` short paths into the smart graph for
-// tenant with id `tenantId`.
-func writeSomeEdges(nrEdges int64, id string, db driver.Database, mutex *sync.Mutex) error {
-	edges, err := db.Collection(nil, "edges")
+// writeSomeEdges writes `nrEdges` random edges in batches of the batch
+// size of the writer with its write mode.
+func writeSomeEdges(ctx context.Context, w *runner.Worker, nrEdges int64, writer *edgeWriter, db driver.Database) error {
+	edges, err := db.Collection(ctx, writer.collectionName)
 	if err != nil {
-		fmt.Printf("writeSomeEdges: could not open `edges` collection: %v\n", err)
+		w.Printf("writeSomeEdges: could not open `%s` collection: %v\n", writer.collectionName, err)
 		return err
 	}
-	eds := make([]Edge, 0, 10000)
-  times := make([]time.Duration, 0, 10000)
-	cyclestart := time.Now()
-	for i := int64(1); i <= nrEdges / 10000; i++ {
+	id := "id_" + strconv.FormatInt(int64(w.ID), 10)
+	eds := make([]Edge, 0, writer.batchSize)
+	var written int64
+	for i, done := int64(1), int64(0); done < nrEdges && ctx.Err() == nil; i++ {
 		start := time.Now()
-    for j := 1; j <= 10000; j++ {
-			fromUid := rand.Intn(10000)
-			toUid := rand.Intn(10000)
-			eds = append(eds, Edge{
-					From: "pubmed/U" + strconv.FormatInt(int64(fromUid), 10),
-					To: "pubmed/U" + strconv.FormatInt(int64(toUid), 10),
-					FromUid: fromUid,
-					ToUid: toUid,
-          Score: rand.Intn(10000000),
-					Last_modified: time.Now().Format(time.RFC3339),
-			})
+		n := writer.nextBatch(done, nrEdges)
+		for j := int64(0); j < n; j++ {
+			eds = append(eds, writer.makeEdge(w, done+j))
 	  }
-		ctx, cancel := context.WithTimeout(driver.WithOverwriteMode(context.Background(), driver.OverwriteModeIgnore), time.Hour)
-		// _, err := edges.ImportDocuments(ctx, eds, &driver.ImportDocumentOptions{})
-		_, _, err := edges.CreateDocuments(ctx, eds)
+		done += n
+		ctx2, cancel := context.WithTimeout(ctx, time.Hour)
+		// _, err := edges.ImportDocuments(ctx2, eds, &driver.ImportDocumentOptions{})
+		nr, err := writer.writeBatch(ctx2, db, edges, eds)
 		cancel()
+		eds = eds[0:0]
 		if err != nil {
-			fmt.Printf("writeSomeEdges: could not write edges: %v\n", err)
-			return err
+			w.Printf("writeSomeEdges: could not write edges: %v\n", err)
+			if err := w.Fail(err); err != nil {
+				return err
+			}
+			continue
 		}
-		eds = eds[0:0]
+		written += nr
 		if i % 100 == 0 {
-			mutex.Lock()
-			fmt.Printf("%s Have imported %d paths for id %s.\n", time.Now(), i * 10000, id)
-			mutex.Unlock()
-		}
-    times = append(times, time.Now().Sub(start))
-		if len(times) % 100 == 0 {
-			sort.Sort(DurationSlice(times))
-			var sum int64 = 0
-			for _, t := range times {
-				sum = sum + int64(t)
-			}
-			totaltime := time.Now().Sub(cyclestart)
-			docspersec := 1000000.0 / (float64(totaltime) / float64(time.Second))
-			mutex.Lock()
-			fmt.Printf("Times for last 100 writes (=1000000 edges): %s (median), %s (90%%ile), %s (99%%ilie), %s (average), edges per second in this go routine: %f\n", times[50], times[90], times[99], time.Duration(sum/100), docspersec)
-			mutex.Unlock()
-			times = times[0:0]
-			cyclestart = time.Now()
+			w.Printf("%s Have imported %d edges for id %s.\n", time.Now(), written, id)
 		}
+		w.Record(start, nr)
 	}
 	return nil
 }
@@ -9,4 +9,5 @@ require (
 	github.com/spf13/cobra v1.0.0
 	golang.org/x/sync v0.0.0-20200625203802-6e8e738ad208
 	golang.org/x/tools v0.0.0-20200818005847-188abfa75333 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
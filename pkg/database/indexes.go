@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/arangodb/go-driver"
+	err2 "github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"time"
+)
+
+// IndexSpec describes one index of an index spec file. The attributes are
+// the ones of the index API of ArangoDB, the index is created with it so
+// that all index types are supported.
+type IndexSpec struct {
+	// Collection restricts the index to the collection with this name,
+	// otherwise it is created on every collection a command creates.
+	Collection   string   `yaml:"collection" json:"-"`
+	Type         string   `yaml:"type" json:"type"`
+	Name         string   `yaml:"name" json:"name,omitempty"`
+	Fields       []string `yaml:"fields" json:"fields"`
+	Unique       bool     `yaml:"unique" json:"unique,omitempty"`
+	Sparse       bool     `yaml:"sparse" json:"sparse,omitempty"`
+	Deduplicate  *bool    `yaml:"deduplicate" json:"deduplicate,omitempty"`
+	Estimates    *bool    `yaml:"estimates" json:"estimates,omitempty"`
+	InBackground bool     `yaml:"inBackground" json:"inBackground,omitempty"`
+	ExpireAfter  *int     `yaml:"expireAfter" json:"expireAfter,omitempty"` // ttl, in seconds
+	GeoJSON      bool     `yaml:"geoJson" json:"geoJson,omitempty"`         // geo
+	MinLength    int      `yaml:"minLength" json:"minLength,omitempty"`     // fulltext
+	Analyzer     string   `yaml:"analyzer" json:"analyzer,omitempty"`       // inverted
+}
+
+// ReadIndexSpecs reads and checks the YAML list of indexes in a file.
+func ReadIndexSpecs(filename string) ([]IndexSpec, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err2.Wrapf(err, "can not read index file: %s", filename)
+	}
+	var specs []IndexSpec
+	if err := yaml.UnmarshalStrict(content, &specs); err != nil {
+		return nil, err2.Wrapf(err, "can not parse index file: %s", filename)
+	}
+	for i := range specs {
+		if err := specs[i].validate(); err != nil {
+			return nil, err2.Wrapf(err, "invalid index %d in %s", i, filename)
+		}
+	}
+	return specs, nil
+}
+
+// validate checks the index spec. The old types hash and skiplist are
+// aliases of persistent.
+func (s *IndexSpec) validate() error {
+	switch s.Type {
+	case "hash", "skiplist":
+		s.Type = "persistent"
+	case "persistent", "inverted":
+	case "ttl":
+		if s.ExpireAfter == nil {
+			return fmt.Errorf("ttl index needs expireAfter")
+		}
+		if len(s.Fields) != 1 {
+			return fmt.Errorf("ttl index needs exactly one field")
+		}
+	case "geo":
+		if len(s.Fields) != 1 && len(s.Fields) != 2 {
+			return fmt.Errorf("geo index needs one or two fields")
+		}
+	case "fulltext":
+		if len(s.Fields) != 1 {
+			return fmt.Errorf("fulltext index needs exactly one field")
+		}
+	default:
+		return fmt.Errorf("unknown index type: %s", s.Type)
+	}
+	if len(s.Fields) == 0 {
+		return fmt.Errorf("%s index needs fields", s.Type)
+	}
+	return nil
+}
+
+// String returns a short description of the index for messages.
+func (s *IndexSpec) String() string {
+	if s.Name != "" {
+		return fmt.Sprintf("%s index %s on %v", s.Type, s.Name, s.Fields)
+	}
+	return fmt.Sprintf("%s index on %v", s.Type, s.Fields)
+}
+
+// EnsureIndex creates the index on the collection unless it exists and
+// returns its id, whether it was created and how long that took.
+func EnsureIndex(ctx context.Context, conn driver.Connection, DBHandle driver.Database, colName string,
+	spec IndexSpec) (string, bool, time.Duration, error) {
+	req, err := conn.NewRequest("POST", "_db/"+url.PathEscape(DBHandle.Name())+"/_api/index")
+	if err != nil {
+		return "", false, 0, err
+	}
+	req.SetQuery("collection", colName)
+	if _, err := req.SetBody(spec); err != nil {
+		return "", false, 0, err
+	}
+	start := time.Now()
+	resp, err := conn.Do(ctx, req)
+	if err != nil {
+		return "", false, 0, err
+	}
+	took := time.Since(start)
+	if err := resp.CheckStatus(200, 201); err != nil {
+		return "", false, took, err
+	}
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := resp.ParseBody("", &result); err != nil {
+		return "", false, took, err
+	}
+	return result.ID, resp.StatusCode() == 201, took, nil
+}
+
+// DropIndex drops the index with the id returned by EnsureIndex.
+func DropIndex(ctx context.Context, conn driver.Connection, DBHandle driver.Database, id string) error {
+	req, err := conn.NewRequest("DELETE", "_db/"+url.PathEscape(DBHandle.Name())+"/_api/index/"+id)
+	if err != nil {
+		return err
+	}
+	resp, err := conn.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	return resp.CheckStatus(200)
+}
+
+// EnsureIndexes creates all indexes of `specs` which apply to the
+// collection and reports the build time of each.
+func EnsureIndexes(ctx context.Context, conn driver.Connection, DBHandle driver.Database, colName string,
+	specs []IndexSpec) error {
+	for _, spec := range specs {
+		if spec.Collection != "" && spec.Collection != colName {
+			continue
+		}
+		_, created, took, err := EnsureIndex(ctx, conn, DBHandle, colName, spec)
+		if err != nil {
+			return err2.Wrapf(err, "can not create %s on %s", spec.String(), colName)
+		}
+		if created {
+			fmt.Printf("Created %s on %s in %v.\n", spec.String(), colName, took)
+		} else {
+			fmt.Printf("Found %s on %s already.\n", spec.String(), colName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package distribution
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+const (
+	// DefaultZipfianSkew is the skew used by YCSB for its zipfian distribution.
+	DefaultZipfianSkew = 0.99
+	// DefaultHotSetFraction and DefaultHotOperationFraction let 80% of the
+	// operations access 20% of the items.
+	DefaultHotSetFraction       = 0.2
+	DefaultHotOperationFraction = 0.8
+)
+
+// Distribution chooses item numbers, e.g. the numbers of the documents
+// written by write batchimport. A Distribution may keep state and must only
+// be used by one go routine.
+type Distribution interface {
+	// Next returns a random item number from 0 to n-1. `n` may grow between
+	// calls when new items are inserted.
+	Next(source *rand.Rand, n int64) int64
+}
+
+// Config describes a distribution.
+type Config struct {
+	// Name is "uniform", "zipfian" (item 0 is the most popular one),
+	// "latest" (the most recently inserted item n-1 is the most popular one)
+	// or "hotspot".
+	Name string
+	// Skew is the zipfian constant, which must be between 0 and 1.
+	Skew float64
+	// HotSetFraction is the fraction of the items which are hot for
+	// "hotspot", HotOperationFraction the fraction of the operations which
+	// access them.
+	HotSetFraction       float64
+	HotOperationFraction float64
+}
+
+// New creates a distribution as described by the config.
+func (c Config) New() (Distribution, error) {
+	switch c.Name {
+	case "uniform":
+		return uniform{}, nil
+	case "zipfian":
+		return newZipfian(c.Skew)
+	case "latest":
+		z, err := newZipfian(c.Skew)
+		if err != nil {
+			return nil, err
+		}
+		return latest{z}, nil
+	case "hotspot":
+		if c.HotSetFraction <= 0 || c.HotSetFraction > 1 {
+			return nil, fmt.Errorf("hot set fraction must be between 0 and 1: %f", c.HotSetFraction)
+		}
+		if c.HotOperationFraction < 0 || c.HotOperationFraction > 1 {
+			return nil, fmt.Errorf("hot operation fraction must be between 0 and 1: %f", c.HotOperationFraction)
+		}
+		return hotspot{c.HotSetFraction, c.HotOperationFraction}, nil
+	}
+	return nil, fmt.Errorf("invalid key distribution: %s", c.Name)
+}
+
+// uniform chooses all items with the same probability.
+type uniform struct{}
+
+func (uniform) Next(source *rand.Rand, n int64) int64 {
+	return source.Int63n(n)
+}
+
+// zipfian chooses item i with a probability proportional to 1/(i+1)^skew,
+// using the algorithm from Gray et al., "Quickly generating billion-record
+// synthetic databases", like YCSB does.
+type zipfian struct {
+	theta float64
+	alpha float64
+	zeta2 float64
+	n     int64   // number of items for which zetan and eta are computed
+	zetan float64 // sum of 1/i^theta for i from 1 to n
+	eta   float64
+}
+
+func newZipfian(skew float64) (*zipfian, error) {
+	if skew <= 0 || skew >= 1 {
+		return nil, fmt.Errorf("zipfian skew must be between 0 and 1: %f", skew)
+	}
+	return &zipfian{
+		theta: skew,
+		alpha: 1.0 / (1.0 - skew),
+		zeta2: 1.0 + math.Pow(0.5, skew),
+	}, nil
+}
+
+// setItems updates zetan and eta for `n` items. If the number of items
+// grows, only the new terms are added to the sum.
+func (z *zipfian) setItems(n int64) {
+	if n < z.n {
+		z.n = 0
+		z.zetan = 0
+	}
+	for i := z.n + 1; i <= n; i++ {
+		z.zetan += 1.0 / math.Pow(float64(i), z.theta)
+	}
+	z.n = n
+	z.eta = (1.0 - math.Pow(2.0/float64(n), 1.0-z.theta)) / (1.0 - z.zeta2/z.zetan)
+}
+
+func (z *zipfian) Next(source *rand.Rand, n int64) int64 {
+	if n != z.n {
+		z.setItems(n)
+	}
+	u := source.Float64()
+	uz := u * z.zetan
+	if uz < 1.0 {
+		return 0
+	}
+	if uz < z.zeta2 && n > 1 {
+		return 1
+	}
+	ret := int64(float64(n) * math.Pow(z.eta*u-z.eta+1.0, z.alpha))
+	if ret >= n {
+		ret = n - 1
+	}
+	return ret
+}
+
+// latest is a zipfian distribution counting from the last item.
+type latest struct {
+	z *zipfian
+}
+
+func (l latest) Next(source *rand.Rand, n int64) int64 {
+	return n - 1 - l.z.Next(source, n)
+}
+
+// hotspot chooses the first hotSetFraction of the items for
+// hotOperationFraction of the calls and one of the other items otherwise,
+// each uniformly.
+type hotspot struct {
+	hotSetFraction       float64
+	hotOperationFraction float64
+}
+
+func (h hotspot) Next(source *rand.Rand, n int64) int64 {
+	hot := int64(float64(n) * h.hotSetFraction)
+	if hot < 1 {
+		hot = 1
+	}
+	if hot >= n || source.Float64() < h.hotOperationFraction {
+		return source.Int63n(hot)
+	}
+	return hot + source.Int63n(n-hot)
+}
@@ -97,9 +97,10 @@ func (c Collection) GetMetrics() (int64, int64) {
 	return int64(size), int64(count)
 }
 
-// CreateDatabases creates databases according to the source input.
+// CreateDatabases creates databases according to the source input. The
+// indexes are created on each collection after its documents.
 func (s *DatabaseMetaData) CreateDatabases(ctx context.Context, client driver.Client,
-	options *driver.CreateDatabaseOptions) error {
+	options *driver.CreateDatabaseOptions, indexes []database.IndexSpec) error {
 
 	var DBHandle driver.Database
 	var colHandle driver.Collection
@@ -140,16 +141,18 @@ func (s *DatabaseMetaData) CreateDatabases(ctx context.Context, client driver.Cl
 			}
 
 			expectedSize, expectedCount := collection.GetMetrics()
-			if expectedCount == 0 {
-				continue
+			if expectedCount > 0 {
+				creator := database.NewCollectionCreator(&database.DocumentsWithEqualLength{
+					ExpectedSize:  expectedSize,
+					ExpectedCount: expectedCount,
+				}, colHandle)
+
+				if err := creator.CreateDocuments(context.Background()); err != nil {
+					return err
+				}
 			}
 
-			creator := database.NewCollectionCreator(&database.DocumentsWithEqualLength{
-				ExpectedSize:  expectedSize,
-				ExpectedCount: expectedCount,
-			}, colHandle)
-
-			if err := creator.CreateDocuments(context.Background()); err != nil {
+			if err := database.EnsureIndexes(ctx, client.Connection(), DBHandle, colName, indexes); err != nil {
 				return err
 			}
 		}
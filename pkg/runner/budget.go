@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/arangodb/go-driver"
+	"github.com/pkg/errors"
+)
+
+// minOperationsForRate is the number of operations which must have been
+// done before the error rate is checked, so that a single early failure
+// does not exceed the budget.
+const minOperationsForRate = 100
+
+// ErrorBudget describes how many failed operations a run tolerates. If
+// both limits are 0 then the first error stops the run.
+type ErrorBudget struct {
+	// MaxErrors is the number of failed operations which are tolerated.
+	MaxErrors int64
+	// MaxErrorRate is the tolerated fraction of failed operations, e.g. 0.01.
+	MaxErrorRate float64
+}
+
+// Enabled returns true if failed operations do not stop the run at once.
+func (b ErrorBudget) Enabled() bool {
+	return b.MaxErrors > 0 || b.MaxErrorRate > 0
+}
+
+// Exceeded returns true if `failed` out of `total` operations are more
+// than the budget allows. The error rate is only checked if `final` is
+// set or enough operations have been done.
+func (b ErrorBudget) Exceeded(failed, total int64, final bool) bool {
+	if !b.Enabled() {
+		return failed > 0
+	}
+	if b.MaxErrors > 0 && failed > b.MaxErrors {
+		return true
+	}
+	if b.MaxErrorRate > 0 && total > 0 && (final || total >= minOperationsForRate) {
+		return float64(failed)/float64(total) > b.MaxErrorRate
+	}
+	return false
+}
+
+// ErrWrongResult marks operations which succeeded on the server but
+// returned an unexpected result.
+var ErrWrongResult = errors.New("wrong result")
+
+// ErrorCode returns the category of an error for the error statistics:
+// "<http code>/<error number>" for server errors, "wrong-result",
+// "timeout", "canceled" or "other".
+func ErrorCode(err error) string {
+	if errors.Cause(err) == ErrWrongResult {
+		return "wrong-result"
+	}
+	if ae, ok := driver.AsArangoError(err); ok && ae.HasError {
+		return fmt.Sprintf("%d/%d", ae.Code, ae.ErrorNum)
+	}
+	if driver.IsTimeout(err) {
+		return "timeout"
+	}
+	if driver.IsCanceled(err) {
+		return "canceled"
+	}
+	return "other"
+}
+
+// errorCounts counts failed operations by error code.
+type errorCounts map[string]int64
+
+// String lists the counts sorted by error code.
+func (e errorCounts) String() string {
+	codes := make([]string, 0, len(e))
+	for code := range e {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	parts := make([]string, 0, len(codes))
+	for _, code := range codes {
+		parts = append(parts, fmt.Sprintf("%s: %d", code, e[code]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// errBudgetExceeded is returned by a run whose error budget was exceeded.
+var errBudgetExceeded = errors.New("error budget exceeded")
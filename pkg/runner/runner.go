@@ -0,0 +1,317 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Config describes how a workload is run in parallel.
+type Config struct {
+	// Name is used in messages and errors, e.g. "writeSomeBatches".
+	Name string
+	// Parallelism is the number of go routines.
+	Parallelism int
+	// FirstID is the id of the first go routine, the others are numbered consecutively.
+	FirstID int
+	// StartDelay is the delay between the start of two go routines.
+	StartDelay time.Duration
+	// Operation names one measured operation in plural, e.g. "batches".
+	Operation string
+	// Items names the processed items in plural, e.g. "docs". It can be empty
+	// if operations do not process a known number of items.
+	Items string
+	// ReportEvery prints the latencies of a go routine after so many
+	// operations in addition to the summary at the end, 0 disables it.
+	ReportEvery int
+	// Budget is the number of failed operations the run tolerates.
+	Budget ErrorBudget
+	// Deterministic seeds the random source of go routine `id` with
+	// Seed+id instead of randomly, so that a run can be repeated with the
+	// same random data.
+	Deterministic bool
+	Seed          int64
+}
+
+// WorkFunc is executed by every go routine of a run. It should stop early
+// when `ctx` is cancelled.
+type WorkFunc func(ctx context.Context, w *Worker) error
+
+// JobFunc is executed for one job `id` by some go routine of a run.
+type JobFunc func(ctx context.Context, w *Worker, id int) error
+
+// Runner runs a workload on a number of go routines, stops all of them
+// on the first error (or when the error budget is exceeded) and aggregates
+// their statistics.
+type Runner struct {
+	Config
+	mutex      sync.Mutex
+	total      *Stats
+	kinds      map[string]*Stats
+	ctx        context.Context
+	operations int64 // successful operations, accessed atomically
+	failed     int64
+	errors     errorCounts
+}
+
+// Worker is the state of one go routine of a run.
+type Worker struct {
+	ID     int
+	Rand   *rand.Rand
+	Stats  *Stats
+	window *Stats
+	kinds  map[string]*Stats
+	// start is the start of the go routine, windowStart the start of the
+	// current reporting window.
+	start       time.Time
+	windowStart time.Time
+	runner      *Runner
+}
+
+// New creates a new runner.
+func New(config Config) *Runner {
+	if config.Parallelism < 1 {
+		config.Parallelism = 1
+	}
+	return &Runner{
+		Config: config,
+		total:  NewStats(),
+		kinds:  make(map[string]*Stats),
+		errors: make(errorCounts),
+	}
+}
+
+// Printf prints to stdout without interleaving with other go routines.
+func (r *Runner) Printf(format string, args ...interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	fmt.Printf(format, args...)
+}
+
+// Run executes `work` once on each of the go routines and returns the
+// statistics of all of them. The first error cancels the context of all
+// other go routines.
+func (r *Runner) Run(work WorkFunc) (*Stats, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.ctx = ctx
+
+	var once sync.Once
+	var firstErr error
+	wg := sync.WaitGroup{}
+	start := time.Now()
+	for i := 0; i < r.Parallelism; i++ {
+		time.Sleep(r.StartDelay)
+		if ctx.Err() != nil {
+			break
+		}
+		w := r.newWorker(r.FirstID + i)
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			r.Printf("Starting go routine %d...\n", w.ID)
+			if err := work(ctx, w); err != nil {
+				r.Printf("%s error: %v\n", r.Name, err)
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+			r.finish(w)
+		}()
+	}
+
+	wg.Wait()
+	r.printTotal(time.Since(start))
+	if firstErr != nil {
+		r.Printf("Error in %s.\n", r.Name)
+		return r.total, errors.Wrapf(firstErr, "error in %s", r.Name)
+	}
+	// Without a budget a go routine only goes on after a failure which it
+	// tolerates itself, e.g. a wrong result, the run has failed anyway:
+	if !r.Budget.Enabled() && r.failed > 0 {
+		r.Printf("Error in %s.\n", r.Name)
+		return r.total, errors.Errorf("error in %s: %d failed out of %d operations (%s)",
+			r.Name, r.failed, r.failed+r.operations, r.errors)
+	}
+	if r.Budget.Exceeded(r.failed, r.failed+r.operations, true) {
+		r.Printf("Error in %s.\n", r.Name)
+		return r.total, errors.Wrapf(errBudgetExceeded, "error in %s: %d failed out of %d operations",
+			r.Name, r.failed, r.failed+r.operations)
+	}
+	return r.total, nil
+}
+
+// RunJobs executes `job` for every id from `first` to `last` (inclusive),
+// the jobs are distributed over the go routines.
+func (r *Runner) RunJobs(first, last int, job JobFunc) (*Stats, error) {
+	ids := make(chan int, last-first+1)
+	for id := first; id <= last; id++ {
+		ids <- id
+	}
+	close(ids)
+
+	return r.Run(func(ctx context.Context, w *Worker) error {
+		for id := range ids {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := job(ctx, w, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *Runner) newWorker(id int) *Worker {
+	seed := int64(id) + rand.Int63()
+	if r.Deterministic {
+		seed = r.Seed + int64(id)
+	}
+	return &Worker{
+		ID:          id,
+		Rand:        rand.New(rand.NewSource(seed)),
+		Stats:       NewStats(),
+		window:      NewStats(),
+		kinds:       make(map[string]*Stats),
+		start:       time.Now(),
+		windowStart: time.Now(),
+		runner:      r,
+	}
+}
+
+// finish prints the statistics of a go routine and adds them to the total.
+func (r *Runner) finish(w *Worker) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if w.Stats.Count() > 0 {
+		fmt.Printf("Times for %d %s: %s%s\n", w.Stats.Count(), r.Operation,
+			w.Stats.Latencies(), r.rates(w.Stats, time.Since(w.start), " in this go routine"))
+	}
+	r.total.Merge(w.Stats)
+	for kind, stats := range w.kinds {
+		if _, ok := r.kinds[kind]; !ok {
+			r.kinds[kind] = NewStats()
+		}
+		r.kinds[kind].Merge(stats)
+	}
+	fmt.Printf("Go routine %d done\n", w.ID)
+}
+
+func (r *Runner) printTotal(totaltime time.Duration) {
+	if r.Items != "" {
+		fmt.Printf("\nTotal number of %s: %d, number of %s: %d, total time: %v%s\n", r.Items, r.total.Items,
+			r.Operation, r.total.Count(), totaltime, r.rates(r.total, totaltime, " in total"))
+	} else {
+		fmt.Printf("\nTotal number of %s: %d, total time: %v%s\n", r.Operation, r.total.Count(),
+			totaltime, r.rates(r.total, totaltime, " in total"))
+	}
+	if r.total.Count() > 0 {
+		fmt.Printf("Times for all %s: %s\n", r.Operation, r.total.Latencies())
+	}
+	for _, kind := range r.Kinds() {
+		s := r.kinds[kind]
+		fmt.Printf("Times for %d %s %s: %s%s\n", s.Count(), kind, r.Operation, s.Latencies(),
+			r.rates(s, totaltime, " in total"))
+	}
+	if r.failed > 0 {
+		fmt.Printf("Failed %s: %d (%s)\n", r.Operation, r.failed, r.errors)
+	}
+}
+
+func (r *Runner) rates(s *Stats, d time.Duration, where string) string {
+	rates := fmt.Sprintf(", %s per second%s: %f", r.Operation, where, PerSecond(int64(s.Count()), d))
+	if r.Items != "" {
+		rates += fmt.Sprintf(", %s per second%s: %f", r.Items, where, PerSecond(s.Items, d))
+	}
+	return rates
+}
+
+// Kinds returns the sorted names of the kinds of operations recorded with
+// RecordAs.
+func (r *Runner) Kinds() []string {
+	kinds := make([]string, 0, len(r.kinds))
+	for kind := range r.kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// KindStats returns the statistics of the operations of one kind after a run.
+func (r *Runner) KindStats(kind string) *Stats {
+	if s, ok := r.kinds[kind]; ok {
+		return s
+	}
+	return NewStats()
+}
+
+// Printf prints to stdout without interleaving with other go routines.
+func (w *Worker) Printf(format string, args ...interface{}) {
+	w.runner.Printf(format, args...)
+}
+
+// Record adds an operation which has begun at `start` and has processed
+// `items` items to the statistics of the go routine.
+func (w *Worker) Record(start time.Time, items int64) {
+	d := time.Since(start)
+	atomic.AddInt64(&w.runner.operations, 1)
+	w.Stats.Add(d, items)
+	if w.runner.ReportEvery == 0 {
+		return
+	}
+	w.window.Add(d, items)
+	if w.window.Count() >= w.runner.ReportEvery {
+		w.runner.mutex.Lock()
+		fmt.Printf("Times for last %d %s of go routine %d: %s%s\n", w.window.Count(), w.runner.Operation,
+			w.ID, w.window.Latencies(), w.runner.rates(w.window, time.Since(w.windowStart), " in this go routine"))
+		w.runner.mutex.Unlock()
+		w.window.Reset()
+		w.windowStart = time.Now()
+	}
+}
+
+// RecordAs works like Record and additionally adds the operation to the
+// statistics of its `kind`, e.g. a query name, which are reported
+// separately at the end of the run.
+func (w *Worker) RecordAs(kind string, start time.Time, items int64) {
+	s, ok := w.kinds[kind]
+	if !ok {
+		s = NewStats()
+		w.kinds[kind] = s
+	}
+	s.Add(time.Since(start), items)
+	w.Record(start, items)
+}
+
+// Fail records a failed operation. It returns nil if the go routine can go
+// on because the error budget still holds, otherwise it returns the error
+// which should end the go routine. The failure is counted in any case.
+func (w *Worker) Fail(err error) error {
+	r := w.runner
+	if r.ctx.Err() != nil {
+		// The run is being stopped, this is not a failure of its own.
+		return err
+	}
+	r.mutex.Lock()
+	r.errors[ErrorCode(err)]++
+	r.failed++
+	failed := r.failed
+	total := failed + atomic.LoadInt64(&r.operations)
+	r.mutex.Unlock()
+	if !r.Budget.Enabled() {
+		return err
+	}
+	if r.Budget.Exceeded(failed, total, false) {
+		return errors.Wrapf(err, "%v after %d failed out of %d operations", errBudgetExceeded, failed, total)
+	}
+	return nil
+}
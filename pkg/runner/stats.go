@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Stats collects the latencies of operations and the number of items
+// (documents, edges, ...) they have processed.
+type Stats struct {
+	Times  []time.Duration
+	Items  int64
+	sorted bool
+}
+
+// NewStats creates empty statistics.
+func NewStats() *Stats {
+	return &Stats{Times: make([]time.Duration, 0, 1000)}
+}
+
+// Add records one operation which took `d` and processed `items` items.
+func (s *Stats) Add(d time.Duration, items int64) {
+	s.Times = append(s.Times, d)
+	s.Items += items
+	s.sorted = false
+}
+
+// Merge adds all operations of `o` to `s`.
+func (s *Stats) Merge(o *Stats) {
+	s.Times = append(s.Times, o.Times...)
+	s.Items += o.Items
+	s.sorted = false
+}
+
+// Reset forgets all recorded operations but keeps the memory.
+func (s *Stats) Reset() {
+	s.Times = s.Times[0:0]
+	s.Items = 0
+	s.sorted = true
+}
+
+// Count returns the number of recorded operations.
+func (s *Stats) Count() int {
+	return len(s.Times)
+}
+
+// Percentile returns the latency below which the fraction `p` of all
+// operations lies, e.g. 0.5 for the median.
+func (s *Stats) Percentile(p float64) time.Duration {
+	if len(s.Times) == 0 {
+		return 0
+	}
+	if !s.sorted {
+		sort.Slice(s.Times, func(a, b int) bool { return s.Times[a] < s.Times[b] })
+		s.sorted = true
+	}
+	i := int(p * float64(len(s.Times)))
+	if i >= len(s.Times) {
+		i = len(s.Times) - 1
+	}
+	return s.Times[i]
+}
+
+// Average returns the average latency of all operations.
+func (s *Stats) Average() time.Duration {
+	if len(s.Times) == 0 {
+		return 0
+	}
+	var sum int64 = 0
+	for _, t := range s.Times {
+		sum = sum + int64(t)
+	}
+	return time.Duration(sum / int64(len(s.Times)))
+}
+
+// Latencies formats median, 90%ile, 99%ile and average latency.
+func (s *Stats) Latencies() string {
+	return fmt.Sprintf("%s (median), %s (90%%ile), %s (99%%ile), %s (average)",
+		s.Percentile(0.5), s.Percentile(0.9), s.Percentile(0.99), s.Average())
+}
+
+// PerSecond returns `n` divided by the duration `d` in seconds.
+func PerSecond(n int64, d time.Duration) float64 {
+	return float64(n) / (float64(d) / float64(time.Second))
+}